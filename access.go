@@ -0,0 +1,92 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cAnyMethod string = "*"
+)
+
+// allow appends callers to the allow-list of method.
+func (s *slot) allow(method string, callers ...string) {
+	s.aclMutex.Lock()
+	defer s.aclMutex.Unlock()
+
+	if nil == s.acl {
+		s.acl = make(map[string][]string)
+	}
+	s.acl[method] = append(s.acl[method], callers...)
+}
+
+// permit checks if the caller slot on dock is allowed to call method. The
+// allow-list of method is used if there is, otherwise the one for all methods.
+// The dock is empty if it's not authenticated, which matches no callers.
+func (s *slot) permit(method string, dock string, caller string) bool {
+	s.aclMutex.Lock()
+	defer s.aclMutex.Unlock()
+
+	callers, ok := s.acl[method]
+	if !ok {
+		callers, ok = s.acl[cAnyMethod]
+	}
+	if !ok {
+		return true
+	}
+	if "" == dock {
+		return false
+	}
+
+	for _, c := range callers {
+		slot, d := splitTarget(c)
+		if "" == d {
+			// The caller without slot is the name of dock.
+			slot, d = "", c
+		}
+		if d == dock && ("" == slot || slot == caller) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func denied(s *slot, method string) error {
+	return newError(CodeDenied, "[%s] access to method [%s] denied!", s.callee.Name(), method)
+}
+
+// admit checks if the call from the dock at peer is allowed. The slots not
+// discoverable are never callable by other docks, and the allow-lists only
+// trust the name of the dock authenticated by guard.
+func (d *dock) admit(peer network.IPeer, target *slot, method string, caller string) error {
+	if !target.discoverable {
+		return newError(CodeDenied, "[%s] slot is private!", target.callee.Name())
+	}
+
+	dock, _ := d.guard.name(peer)
+	if !target.permit(method, dock, caller) {
+		return denied(target, method)
+	}
+
+	return nil
+}
+
+// refuse responds the rpc request with err, and the caller sends it to other
+// dock if retry.
+func refuse(peer network.IPeer, req *protoRpcRequest, err error, retry bool) {
+	resp := &protoRpcResponse{
+		Index:  req.Index,
+		Slot:   req.Slot,
+		Method: req.Method,
+		Retry:  retry,
+	}
+	resp.Err, resp.Code, resp.Details = flatten(err)
+	peer.Send(&packer{
+		Id: cRpcResponse,
+		P:  resp,
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cNonceSize int = 16
+	cIdSize    int = 8
+)
+
+func newGuard(secret string, tokens map[string]string) *guard {
+	return &guard{
+		secret: secret,
+		tokens: tokens,
+		nonces: make(map[network.IPeer]string),
+		passes: make(map[network.IPeer]string),
+	}
+}
+
+// guard authenticates the incoming peers with HMAC challenge, and it's
+// disabled if there is neither secret nor tokens.
+type guard struct {
+	sync.Mutex
+	secret string
+	tokens map[string]string
+	nonces map[network.IPeer]string // nonce sent to the challenged peers.
+	passes map[network.IPeer]string // name of the authenticated peers.
+}
+
+func (g *guard) enabled() bool {
+	return "" != g.secret || len(g.tokens) > 0
+}
+
+// challenge sends a random nonce to peer.
+func (g *guard) challenge(peer network.IPeer) {
+	nonce := randomHex(cNonceSize)
+
+	g.Lock()
+	g.nonces[peer] = nonce
+	g.Unlock()
+
+	peer.Send(&packer{
+		Id: cChallenge,
+		P:  &protoChallenge{Nonce: nonce},
+	})
+}
+
+// verify checks the answer from peer with the secret of its name.
+func (g *guard) verify(peer network.IPeer, auth *protoAuth) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	nonce, ok := g.nonces[peer]
+	if !ok {
+		return false
+	}
+	delete(g.nonces, peer)
+
+	secret, ok := g.tokens[auth.Name]
+	if !ok {
+		secret = g.secret
+	}
+	if "" == secret {
+		return false
+	}
+
+	expect := sign(secret, nonce, auth.Name)
+	if !hmac.Equal([]byte(expect), []byte(auth.Mac)) {
+		return false
+	}
+
+	g.passes[peer] = auth.Name
+	return true
+}
+
+func (g *guard) passed(peer network.IPeer) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	_, ok := g.passes[peer]
+	return ok
+}
+
+// name returns the name of the authenticated peer.
+func (g *guard) name(peer network.IPeer) (string, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	name, ok := g.passes[peer]
+	return name, ok
+}
+
+func (g *guard) forget(peer network.IPeer) {
+	g.Lock()
+	defer g.Unlock()
+
+	delete(g.nonces, peer)
+	delete(g.passes, peer)
+}
+
+// reject tells peer it's not authenticated and closes it.
+func (g *guard) reject(peer network.IPeer) {
+	peer.Send(&packer{
+		Id: cError,
+		P:  &protoError{Error: "Authentication failed!"},
+	})
+	peer.Close()
+}
+
+// answer responds the challenge with name and secret.
+func answer(peer network.IPeer, name string, secret string, challenge *protoChallenge) {
+	peer.Send(&packer{
+		Id: cAuth,
+		P: &protoAuth{
+			Name: name,
+			Mac:  sign(secret, challenge.Nonce, name),
+		},
+	})
+}
+
+// randomHex returns a hex string of random bytes in size.
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func sign(secret string, nonce string, name string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
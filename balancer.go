@@ -0,0 +1,117 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// IBalancer interface to choose one from all ready instances of a slot for
+// each call. Pick is always called serially by hub, so it's safe to keep
+// state without lock.
+type IBalancer interface {
+	// Pick index of instances to serve the querying dock identified by key.
+	Pick(key string, instances []Instance) int
+}
+
+// Instance of a slot carried by a dock.
+type Instance struct {
+	Name string // name of the dock.
+	Addr string // addr of the dock, which may be allocated by hub.
+}
+
+// RoundRobin create a balancer picking instances in turn.
+func RoundRobin() IBalancer {
+	return new(roundRobin)
+}
+
+// Random create a balancer picking instances randomly.
+func Random() IBalancer {
+	return new(random)
+}
+
+// Weighted create a balancer picking instances randomly by the weight of
+// dock name, and the weight of a dock not in weights is 1.
+func Weighted(weights map[string]int) IBalancer {
+	return &weighted{weights: weights}
+}
+
+// Sticky create a balancer always picking the same instance for the same key
+// as long as the instance is available.
+func Sticky() IBalancer {
+	return &sticky{picks: make(map[string]string)}
+}
+
+type roundRobin struct {
+	next int
+}
+
+func (b *roundRobin) Pick(key string, instances []Instance) int {
+	index := b.next % len(instances)
+	b.next = index + 1
+
+	return index
+}
+
+type random struct {
+}
+
+func (b *random) Pick(key string, instances []Instance) int {
+	return rand.Intn(len(instances))
+}
+
+type weighted struct {
+	weights map[string]int
+}
+
+func (b *weighted) Pick(key string, instances []Instance) int {
+	total := 0
+	for _, v := range instances {
+		total += b.weight(v.Name)
+	}
+	if total <= 0 {
+		return rand.Intn(len(instances))
+	}
+
+	n := rand.Intn(total)
+	for i, v := range instances {
+		n -= b.weight(v.Name)
+		if n < 0 {
+			return i
+		}
+	}
+
+	return len(instances) - 1
+}
+
+func (b *weighted) weight(name string) int {
+	if w, ok := b.weights[name]; ok {
+		return w
+	}
+
+	return 1
+}
+
+type sticky struct {
+	picks map[string]string
+}
+
+func (b *sticky) Pick(key string, instances []Instance) int {
+	if addr, ok := b.picks[key]; ok {
+		for i, v := range instances {
+			if v.Addr == addr {
+				return i
+			}
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	index := int(h.Sum32() % uint32(len(instances)))
+	b.picks[key] = instances[index].Addr
+
+	return index
+}
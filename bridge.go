@@ -0,0 +1,174 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"log"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+// bridge is the connection dialed from dock to another dock at addr.
+type bridge struct {
+	dock *dock
+	addr string
+}
+
+func (b *bridge) OnConnected(peer network.IPeer) {
+	b.dock.bind(b.addr, peer)
+
+	// Authenticate the dialed dock too as the link is shared by its calls,
+	// but after answering its challenge if it has one. The challenge is sent
+	// before registering, so the answer arrives ahead of its calls.
+	if "" == b.dock.conf.Secret && b.dock.guard.enabled() {
+		b.dock.guard.challenge(peer)
+	}
+	b.dock.OnConnected(peer)
+}
+
+func (b *bridge) OnClosed(peer network.IPeer) {
+	b.dock.linksMutex.Lock()
+	delete(b.dock.sockets, b.addr)
+	b.dock.linksMutex.Unlock()
+
+	b.dock.OnClosed(peer)
+}
+
+func (b *bridge) OnPacket(peer network.IPeer, obj interface{}) {
+	d := b.dock
+	pack := obj.(*packer)
+	switch pack.Id {
+	case cAuth:
+		if !d.guard.verify(peer, pack.P.(*protoAuth)) {
+			log.Printf("[%s] authentication of dialed dock failed.", peer.RemoteAddr())
+			d.guard.reject(peer)
+		}
+		return
+	case cChallenge:
+		answer(peer, d.name, d.conf.Secret, pack.P.(*protoChallenge))
+		if d.guard.enabled() {
+			d.guard.challenge(peer)
+		}
+		go d.register(peer)
+		return
+	case cRpcRequest, cRpcNotify, cRpcCancel, cStreamData, cStreamCredit, cStreamEnd:
+		// The dialed dock answers the challenge before calling through the
+		// link.
+		if d.guard.enabled() && !d.guard.passed(peer) {
+			log.Printf("[%s] call from unauthenticated dialed dock.", peer.RemoteAddr())
+			d.guard.reject(peer)
+			return
+		}
+	}
+
+	d.OnPacket(peer, obj)
+}
+
+// reach makes sure there is a connection to the dock at addr which hosts
+// slot, and dispatches the calls waiting for the slot if connected already.
+// The dock is the name or id targeted by the calls, or empty.
+func (d *dock) reach(slot string, dock string, addr string) {
+	d.linksMutex.Lock()
+	peer, linked := d.links[addr]
+	_, dialing := d.sockets[addr]
+	if linked || dialing {
+		d.linksMutex.Unlock()
+		if linked {
+			d.remoteSlotsMutex.Lock()
+			d.remoteSlots[joinTarget(slot, dock)] = peer
+			d.remoteSlotsMutex.Unlock()
+			d.resume([]string{slot})
+		}
+		return
+	}
+
+	socket := network.NewSocket(addr, "ferry", &bridge{dock: d, addr: addr})
+	d.sockets[addr] = socket
+	d.linksMutex.Unlock()
+
+	if err := socket.Dial(); nil != err {
+		log.Printf("Connect to dock [%s] failed: %s", addr, err)
+
+		d.linksMutex.Lock()
+		if d.sockets[addr] == socket {
+			delete(d.sockets, addr)
+		}
+		d.linksMutex.Unlock()
+	}
+}
+
+// bind shares the connection with the dock at addr, and returns the one in
+// use if there is already. Two docks dialing each other at the same time may
+// have an extra connection, which is left idle.
+func (d *dock) bind(addr string, peer network.IPeer) network.IPeer {
+	if "" == addr {
+		return peer
+	}
+
+	d.linksMutex.Lock()
+	defer d.linksMutex.Unlock()
+
+	if p, ok := d.links[addr]; ok {
+		return p
+	}
+	d.links[addr] = peer
+
+	return peer
+}
+
+// linked returns true if peer is the connection in use to other dock.
+func (d *dock) linked(peer network.IPeer) bool {
+	d.linksMutex.Lock()
+	defer d.linksMutex.Unlock()
+
+	for _, p := range d.links {
+		if p == peer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// learn routes the calls to slots of the dock named name with id through
+// link, including the ones targeting the dock.
+func (d *dock) learn(link network.IPeer, slots []string, name string, id string) {
+	d.remoteSlotsMutex.Lock()
+	defer d.remoteSlotsMutex.Unlock()
+
+	for _, v := range slots {
+		d.remoteSlots[v] = link
+		for _, dock := range []string{name, id} {
+			if "" != dock {
+				d.remoteSlots[joinTarget(v, dock)] = link
+			}
+		}
+	}
+}
+
+// unbind forgets the connection to other dock.
+func (d *dock) unbind(peer network.IPeer) {
+	d.linksMutex.Lock()
+	defer d.linksMutex.Unlock()
+
+	for addr, p := range d.links {
+		if p == peer {
+			delete(d.links, addr)
+		}
+	}
+}
+
+// peers returns the connections to all other docks.
+func (d *dock) peers() []network.IPeer {
+	d.linksMutex.Lock()
+	defer d.linksMutex.Unlock()
+
+	peers := make([]network.IPeer, 0, len(d.links))
+	for _, p := range d.links {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+// Gather tells how many successful replies a broadcast waits for, and a
+// positive one means the first N replies.
+type Gather int
+
+const (
+	GatherAll    Gather = 0  // All instances
+	GatherQuorum Gather = -1 // Majority of instances
+)
+
+// need returns the count of successful replies needed from n instances.
+func (g Gather) need(n int) int {
+	switch {
+	case GatherAll == g:
+		return n
+	case GatherQuorum == g:
+		return n/2 + 1
+	default:
+		return int(g)
+	}
+}
+
+// Reply of a slot instance to broadcast.
+type Reply struct {
+	// Name of the dock hosting the slot instance.
+	Name string
+
+	// ID of the dock hosting the slot instance.
+	ID string
+
+	// Result of the call.
+	Result []interface{}
+
+	// Err of the call.
+	Err error
+}
+
+// broadcast calls method of all ready instances of slot id, and returns the
+// replies once enough of them succeed. The calls left still go on.
+func (d *dock) broadcast(ctx context.Context, caller string, id string, method string, gather Gather, args ...interface{}) ([]Reply, error) {
+	list, err := d.list(ctx, id)
+	if nil != err {
+		return nil, err
+	}
+
+	replies := make(chan Reply, len(list.Ids))
+	for i := range list.Ids {
+		go func(name string, dock string) {
+			result, err := d.callWithResult(ctx, caller, joinTarget(id, dock), method, args...)
+			replies <- Reply{Name: name, ID: dock, Result: result, Err: err}
+		}(list.Names[i], list.Ids[i])
+	}
+
+	need := gather.need(len(list.Ids))
+	gathered := make([]Reply, 0, len(list.Ids))
+	succeeded := 0
+	for succeeded < need && len(gathered) < len(list.Ids) {
+		select {
+		case r := <-replies:
+			gathered = append(gathered, r)
+			if nil == r.Err {
+				succeeded++
+			}
+		case <-ctx.Done():
+			return gathered, ctx.Err()
+		}
+	}
+
+	if succeeded < need {
+		return gathered, fmt.Errorf("[%s] broadcast gets %d successful replies of %d needed!", id, succeeded, need)
+	}
+
+	return gathered, nil
+}
+
+// list asks hub for the docks hosting ready instances of slot.
+func (d *dock) list(ctx context.Context, slot string) (*protoListResponse, error) {
+	hub := d.currentHub()
+	if nil == hub {
+		return nil, fmt.Errorf("[%s] hub is not connected!", slot)
+	}
+
+	index := atomic.AddInt64(&rpcIndex, 1)
+	ch := make(chan *protoListResponse, 1)
+	d.listsMutex.Lock()
+	d.lists[index] = ch
+	d.listsMutex.Unlock()
+	defer func() {
+		d.listsMutex.Lock()
+		delete(d.lists, index)
+		d.listsMutex.Unlock()
+	}()
+
+	hub.Send(&packer{
+		Id: cListRequest,
+		P:  &protoListRequest{Index: index, Slot: slot},
+	})
+
+	timer := time.NewTimer(seconds(d.conf.CallTimeout))
+	defer timer.Stop()
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("[%s] list timeout!", slot)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// listed delivers the list from hub to the broadcast waiting for it.
+func (d *dock) listed(resp *protoListResponse) {
+	d.listsMutex.Lock()
+	defer d.listsMutex.Unlock()
+
+	if ch, ok := d.lists[resp.Index]; ok {
+		ch <- resp
+		delete(d.lists, resp.Index)
+	}
+}
+
+// list responds peer the docks hosting ready instances of slot.
+func (h *hub) list(peer network.IPeer, req *protoListRequest) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	resp := &protoListResponse{
+		Index: req.Index,
+		Slot:  req.Slot,
+		Ids:   make([]string, 0),
+		Names: make([]string, 0),
+	}
+	if stubs, ok := h.docks[req.Slot]; ok {
+		for i := stubs.Front(); i != nil; i = i.Next() {
+			stub := i.Value.(*stub)
+			if !stub.ready {
+				continue
+			}
+			if b, ok := h.berthOf(stub); ok && "" != b.id {
+				resp.Ids = append(resp.Ids, b.id)
+				resp.Names = append(resp.Names, b.name)
+			}
+		}
+	}
+
+	peer.Send(&packer{
+		Id: cListResponse,
+		P:  resp,
+	})
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"fmt"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+// carry adds slot to the running dock, and tells hub and the connected docks
+// if it's discoverable.
+func (d *dock) carry(s *slot) error {
+	id := s.callee.Name()
+
+	d.slotsMutex.Lock()
+	if nil == d.slots {
+		d.slotsMutex.Unlock()
+		return fmt.Errorf("[%s] dock is closed!", id)
+	}
+	if _, ok := d.slots[id]; ok {
+		d.slotsMutex.Unlock()
+		return fmt.Errorf("[%s] slot is carried already!", id)
+	}
+	s.dock = d
+	d.slots[id] = s
+	delete(d.gone, id)
+	started := d.started
+	d.slotsMutex.Unlock()
+
+	// The slot is registered with others when connected to hub if not
+	// started yet.
+	if s.discoverable && started {
+		register := &packer{
+			Id: cRegisterRequest,
+			P: &protoRegisterRequest{
+				Slots: []string{id},
+				Addr:  d.address(),
+				Name:  d.name,
+				Id:    d.id,
+			},
+		}
+		for _, peer := range d.peers() {
+			peer.Send(register)
+		}
+
+		if hub := d.currentHub(); nil != hub {
+			hub.Send(register)
+			hub.Send(&packer{
+				Id: cReady,
+				P: &protoReady{
+					Slots: []string{id},
+				},
+			})
+		}
+	}
+
+	if started {
+		go s.feature.OnStart(s)
+	}
+
+	return nil
+}
+
+// uncarry removes slot from the running dock, and tells hub and the connected
+// docks if it's discoverable. The calls to the slot are refused to retry on
+// other docks, and the slot is destroyed after the calls in progress.
+func (d *dock) uncarry(id string) error {
+	d.slotsMutex.Lock()
+	s, ok := d.slots[id]
+	if !ok {
+		d.slotsMutex.Unlock()
+		return fmt.Errorf("[%s] slot is not carried!", id)
+	}
+	delete(d.slots, id)
+	d.gone[id] = true
+	d.slotsMutex.Unlock()
+
+	if s.discoverable {
+		deregister := &packer{
+			Id: cDeregister,
+			P: &protoDeregister{
+				Slots: []string{id},
+				Addr:  d.address(),
+			},
+		}
+		if hub := d.currentHub(); nil != hub {
+			hub.Send(deregister)
+		}
+		for _, peer := range d.peers() {
+			peer.Send(deregister)
+		}
+	}
+
+	s.leave(d.conf.DrainTimeout)
+	d.mute(s)
+	d.ignore(s)
+	s.feature.OnDestroy(s)
+	s.callee.Close()
+
+	return nil
+}
+
+// left returns true if slot id is uncarried from this dock.
+func (d *dock) left(id string) bool {
+	d.slotsMutex.Lock()
+	defer d.slotsMutex.Unlock()
+
+	return d.gone[id]
+}
+
+// find returns the slot carried by this dock, or nil if there is no one.
+func (d *dock) find(id string) *slot {
+	d.slotsMutex.Lock()
+	defer d.slotsMutex.Unlock()
+
+	return d.slots[id]
+}
+
+// launch marks dock as started, and returns all slots to start if it's not
+// started before.
+func (d *dock) launch() (bool, []*slot) {
+	d.slotsMutex.Lock()
+	defer d.slotsMutex.Unlock()
+
+	if d.started {
+		return false, nil
+	}
+	d.started = true
+
+	slots := make([]*slot, 0, len(d.slots))
+	for _, s := range d.slots {
+		slots = append(slots, s)
+	}
+
+	return true, slots
+}
+
+// forget removes the slots hosted by the dock at peer.
+func (d *dock) forget(peer network.IPeer, req *protoDeregister) {
+	link := d.bind(req.Addr, peer)
+
+	d.remoteSlotsMutex.Lock()
+	defer d.remoteSlotsMutex.Unlock()
+
+	for target, p := range d.remoteSlots {
+		if p != peer && p != link {
+			continue
+		}
+
+		slot, _ := splitTarget(target)
+		for _, id := range req.Slots {
+			if id == slot {
+				delete(d.remoteSlots, target)
+				break
+			}
+		}
+	}
+}
+
+// extend adds slots to the dock registered already, and returns false if the
+// dock is not registered.
+func (h *hub) extend(peer network.IPeer, slots []string) bool {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	b, ok := h.berths[peer]
+	if !ok {
+		return false
+	}
+
+	added := exclude(slots, b.slots)
+	b.slots = append(append([]string{}, b.slots...), added...)
+	h.stub(added, b.addr, peer)
+	h.sync(cSyncCarry, b, added)
+	h.persist()
+
+	return true
+}
+
+// shrink removes slots from the dock registered already.
+func (h *hub) shrink(peer network.IPeer, slots []string) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	b, ok := h.berths[peer]
+	if !ok {
+		return
+	}
+
+	removed := exclude(slots, exclude(slots, b.slots))
+	b.slots = exclude(b.slots, removed)
+	h.unstub(removed, b.addr, peer)
+	h.sync(cSyncUncarry, b, removed)
+	h.persist()
+}
+
+// exclude returns the slots not in others.
+func exclude(slots []string, others []string) []string {
+	result := make([]string, 0, len(slots))
+	for _, s := range slots {
+		found := false
+		for _, o := range others {
+			if s == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
@@ -4,6 +4,10 @@
 
 package chancall
 
+import (
+	"context"
+)
+
 // ICallee interface.
 type ICallee interface {
 	// Return callee's name.
@@ -11,6 +15,9 @@ type ICallee interface {
 
 	// Set target method timeout duration.
 	SetTimeout(name string, timeout float32)
+
+	// Close stops the routine of callee, and the calls not served yet fail.
+	Close()
 }
 
 // ICaller interface.
@@ -20,6 +27,20 @@ type ICaller interface {
 
 	// Call "name" method followed args and has return values.
 	CallWithResult(name string, args ...interface{}) ([]interface{}, error)
+
+	// Call like Call but stops waiting when ctx is done. The method gets ctx
+	// if its first parameter is context.Context.
+	CallContext(ctx context.Context, name string, args ...interface{}) error
+
+	// Call like CallWithResult but stops waiting when ctx is done. The method
+	// gets ctx if its first parameter is context.Context.
+	CallWithResultContext(ctx context.Context, name string, args ...interface{}) ([]interface{}, error)
+
+	// Call like CallWithResultContext but not limited by the method timeout,
+	// for the method serving a stream which runs long. With ctx from
+	// Yieldable, the method gives up the routine of callee while blocked in
+	// Block, so other calls go on in between but never along with it.
+	CallStream(ctx context.Context, name string, args ...interface{}) ([]interface{}, error)
 }
 
 // NewCallee create a new callee with unique name and target object.
@@ -27,12 +48,33 @@ func NewCallee(name string, target interface{}) ICallee {
 	c := new(callee)
 	c.meta = newMeta(name, target)
 	c.callRequest = make(chan *callRequest, 2)
+	c.quit = make(chan struct{})
 	c.functions = make(map[string]*fcall)
 	go c.handling()
 
 	return c
 }
 
+// Yieldable returns a copy of ctx for one CallStream, with which the method
+// serving the stream could give up the routine of callee in Block.
+func Yieldable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, turnKey{}, newTurn())
+}
+
+// Block runs wait, and the method serving the stream with ctx from Yieldable
+// gives up the routine of callee meanwhile and gets it back after. It's the
+// same as calling wait for other ctx.
+func Block(ctx context.Context, wait func()) {
+	t, ok := ctx.Value(turnKey{}).(*turn)
+	if !ok || !t.give() {
+		wait()
+		return
+	}
+
+	wait()
+	t.take()
+}
+
 // NewCaller create a caller for target callee.
 func NewCaller(c ICallee) ICaller {
 	caller := new(caller)
@@ -41,3 +83,25 @@ func NewCaller(c ICallee) ICaller {
 
 	return caller
 }
+
+// Kind of the call error.
+type Kind int
+
+const (
+	KindNotFound Kind = iota + 1 // Method not found
+	KindBadArgs                  // Args don't match the method
+	KindTimeout                  // Method runs over its timeout
+	KindPanic                    // Method panics
+	KindClosed                   // Callee is closed
+)
+
+// Error of the call failed in callee.
+type Error struct {
+	Kind    Kind
+	Message string
+	Stack   string // Stack of the method panicking.
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
@@ -5,8 +5,10 @@
 package chancall_test
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,6 +41,10 @@ func (t targetObject) LongWaitCall() {
 	time.Sleep(2 * time.Second)
 }
 
+func (t targetObject) Crash() {
+	panic("crash")
+}
+
 func TestNormalCall(t *testing.T) {
 	callee := chancall.NewCallee("target", new(targetObject))
 
@@ -95,3 +101,122 @@ func TestTimeout(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestBadCall(t *testing.T) {
+	callee := chancall.NewCallee("target", new(targetObject))
+	caller := chancall.NewCaller(callee)
+
+	if err := caller.Call("Missing"); nil == err {
+		t.Error("Call to missing method should fail!")
+	}
+	if _, err := caller.CallWithResult("Add", 1); nil == err {
+		t.Error("Call with wrong args count should fail!")
+	}
+	if _, err := caller.CallWithResult("Add", 1, "2"); nil == err {
+		t.Error("Call with wrong args type should fail!")
+	}
+	if r, err := caller.CallWithResult("Add", int8(1), int64(2)); nil != err || 3 != r[0].(int) {
+		t.Errorf("Call with convertible args failed: %v %v", r, err)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	callee := chancall.NewCallee("target", new(targetObject))
+	caller := chancall.NewCaller(callee)
+
+	err := caller.Call("Crash")
+	if e, ok := err.(*chancall.Error); !ok || chancall.KindPanic != e.Kind || "" == e.Stack {
+		t.Errorf("Call to panicking method should fail with stack: %v", err)
+	}
+	if r, err := caller.CallWithResult("F1"); nil != err || 1 != r[0].(int) {
+		t.Errorf("Call after panic failed: %v %v", r, err)
+	}
+}
+
+type streamObject struct {
+	started chan bool
+	busy    int32
+	overlap int32
+}
+
+func (s *streamObject) Hold(ctx context.Context) {
+	s.enter()
+	s.started <- true
+	s.leave()
+
+	chancall.Block(ctx, func() {
+		<-ctx.Done()
+	})
+
+	s.enter()
+	time.Sleep(10 * time.Millisecond)
+	s.leave()
+}
+
+func (s *streamObject) F1() int {
+	s.enter()
+	defer s.leave()
+
+	time.Sleep(time.Millisecond)
+	return 1
+}
+
+func (s *streamObject) enter() {
+	if atomic.AddInt32(&s.busy, 1) > 1 {
+		atomic.StoreInt32(&s.overlap, 1)
+	}
+}
+
+func (s *streamObject) leave() {
+	atomic.AddInt32(&s.busy, -1)
+}
+
+func TestStreamCall(t *testing.T) {
+	target := &streamObject{started: make(chan bool, 1)}
+	callee := chancall.NewCallee("target", target)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	held := make(chan bool, 1)
+	go func() {
+		chancall.NewCaller(callee).CallStream(chancall.Yieldable(ctx), "Hold")
+		held <- true
+	}()
+	<-target.started
+
+	// The method serving stream gives up the routine while blocked.
+	if r, err := chancall.NewCaller(callee).CallWithResult("F1"); nil != err || 1 != r[0].(int) {
+		t.Errorf("Call during stream failed: %v %v", r, err)
+	}
+
+	cancel()
+	for i := 0; i < 5; i++ {
+		chancall.NewCaller(callee).Call("F1")
+	}
+	select {
+	case <-held:
+	case <-time.After(time.Second):
+		t.Error("Stream call should return after cancelled!")
+	}
+
+	if 0 != atomic.LoadInt32(&target.overlap) {
+		t.Error("Stream method runs along with other calls!")
+	}
+}
+
+func TestClose(t *testing.T) {
+	callee := chancall.NewCallee("target", new(targetObject))
+	caller := chancall.NewCaller(callee)
+
+	if r, err := caller.CallWithResult("F1"); nil != err || 1 != r[0].(int) {
+		t.Errorf("Call before closed failed: %v %v", r, err)
+	}
+
+	callee.Close()
+	callee.Close()
+	for i := 0; i < 5; i++ {
+		err := caller.Call("F1")
+		if e, ok := err.(*chancall.Error); !ok || chancall.KindClosed != e.Kind {
+			t.Errorf("Call after closed should fail with KindClosed: %v", err)
+		}
+	}
+}
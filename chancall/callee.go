@@ -6,12 +6,15 @@ package chancall
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
 type callee struct {
 	meta        *meta
 	callRequest chan *callRequest
+	quit        chan struct{}
+	quitOnce    sync.Once
 	functions   map[string]*fcall
 }
 
@@ -23,19 +26,56 @@ func (c *callee) SetTimeout(name string, timeout float32) {
 	c.meta.setTimeout(name, timeout)
 }
 
+func (c *callee) Close() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+	})
+}
+
 func (c *callee) handling() {
 	for {
-		err := c.process(<-c.callRequest)
-		if nil != err {
-			panic(fmt.Sprintf("Invoke error %s", err.Error()))
+		select {
+		case request := <-c.callRequest:
+			err := c.process(request)
+			if nil != err {
+				panic(fmt.Sprintf("Invoke error %s", err.Error()))
+			}
+		case <-c.quit:
+			return
 		}
 	}
 }
 
+// closed returns the error of the call to closed callee.
+func (c *callee) closed() error {
+	return &Error{
+		Kind:    KindClosed,
+		Message: fmt.Sprintf("[%s] callee is closed!", c.meta.name),
+	}
+}
+
 func (c *callee) process(request *callRequest) (err error) {
+	if nil != request.turn {
+		request.turn.hand()
+		return nil
+	}
+	if request.untimed {
+		// The method serving a stream lends the routine to others while
+		// blocked if it's yieldable.
+		if t, ok := request.ctx.Value(turnKey{}).(*turn); ok {
+			t.lend(c, request)
+			return nil
+		}
+		return c.serve(request)
+	}
+
 	track(request, c.meta.timeout(request.method))
-	result := c.meta.call(request.method, request.args...)
-	return c.result(request, &callResponse{result: result})
+	return c.serve(request)
+}
+
+func (c *callee) serve(request *callRequest) error {
+	result, err := c.meta.call(request.ctx, request.method, request.args...)
+	return c.result(request, &callResponse{result: result, err: err})
 }
 
 func (c *callee) result(request *callRequest, response *callResponse) (err error) {
@@ -69,7 +109,10 @@ func track(request *callRequest, timeout float32) {
 				request.done = true
 				request.callResponse <- &callResponse{
 					result: nil,
-					err:    fmt.Errorf("[%s] function call timeout!", request.method),
+					err: &Error{
+						Kind:    KindTimeout,
+						Message: fmt.Sprintf("[%s] function call timeout!", request.method),
+					},
 				}
 			}
 		}
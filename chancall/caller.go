@@ -5,6 +5,7 @@
 package chancall
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -14,35 +15,73 @@ type caller struct {
 }
 
 func (c *caller) Call(method string, args ...interface{}) error {
-	err := c.call(&callRequest{
+	return c.CallContext(context.Background(), method, args...)
+}
+
+func (c *caller) CallWithResult(method string, args ...interface{}) ([]interface{}, error) {
+	return c.CallWithResultContext(context.Background(), method, args...)
+}
+
+func (c *caller) CallContext(ctx context.Context, method string, args ...interface{}) error {
+	_, err := c.CallWithResultContext(ctx, method, args...)
+	return err
+}
+
+func (c *caller) CallWithResultContext(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	return c.invoke(&callRequest{
+		ctx:          ctx,
 		method:       method,
 		args:         args,
 		callResponse: c.callResponse,
 		done:         false,
-	}, true)
-	if nil != err {
-		return err
-	}
-
-	response := <-c.callResponse
-	return response.err
+	})
 }
 
-func (c *caller) CallWithResult(method string, args ...interface{}) ([]interface{}, error) {
-	err := c.call(&callRequest{
+func (c *caller) CallStream(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	return c.invoke(&callRequest{
+		ctx:          ctx,
 		method:       method,
 		args:         args,
 		callResponse: c.callResponse,
 		done:         false,
-	}, true)
+		untimed:      true,
+	})
+}
+
+func (c *caller) invoke(request *callRequest) ([]interface{}, error) {
+	err := c.call(request, true)
 	if nil != err {
 		return nil, err
 	}
 
-	response := <-c.callResponse
+	response := c.wait(request)
 	return response.result, response.err
 }
 
+// wait returns the response, or the error of ctx or closed callee if it's
+// first.
+func (c *caller) wait(request *callRequest) *callResponse {
+	var err error
+	select {
+	case response := <-c.callResponse:
+		return response
+	case <-request.ctx.Done():
+		err = request.ctx.Err()
+	case <-c.callee.quit:
+		err = c.callee.closed()
+	}
+
+	request.Lock()
+	if !request.done {
+		request.done = true
+		request.Unlock()
+		return &callResponse{err: err}
+	}
+	request.Unlock()
+
+	return <-c.callResponse
+}
+
 func (c *caller) call(request *callRequest, block bool) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -51,10 +90,16 @@ func (c *caller) call(request *callRequest, block bool) (err error) {
 	}()
 
 	if block {
-		c.callee.callRequest <- request
+		select {
+		case c.callee.callRequest <- request:
+		case <-c.callee.quit:
+			err = c.callee.closed()
+		}
 	} else {
 		select {
 		case c.callee.callRequest <- request:
+		case <-c.callee.quit:
+			err = c.callee.closed()
 		default:
 			err = fmt.Errorf("RPC channel full!")
 		}
@@ -5,15 +5,19 @@
 package chancall
 
 import (
+	"context"
 	"sync"
 )
 
 type callRequest struct {
 	sync.Mutex
+	ctx          context.Context
 	method       string
 	args         []interface{}
 	callResponse chan *callResponse
 	done         bool
+	untimed      bool  // not limited by the method timeout.
+	turn         *turn // the method serving a stream taking the routine back.
 }
 
 type callResponse struct {
@@ -5,9 +5,14 @@
 package chancall
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"runtime/debug"
 )
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 func newMeta(name string, target interface{}) *meta {
 	m := new(meta)
 	m.name = name
@@ -41,24 +46,105 @@ func (m *meta) collect(target interface{}) {
 	}
 }
 
-func (m *meta) call(method string, args ...interface{}) []interface{} {
+// call runs the method with args, and the panic of the method is recovered as
+// error so that the callee keeps serving.
+func (m *meta) call(ctx context.Context, method string, args ...interface{}) (result []interface{}, err error) {
 	f := m.funcs[method]
-	if nil != f && f.fn.IsValid() {
-		params := make([]reflect.Value, 0)
-		for i, arg := range args {
-			params = append(params, reflect.ValueOf(arg).Convert(f.ft.In(i)))
+	if nil == f || !f.fn.IsValid() {
+		return nil, &Error{
+			Kind:    KindNotFound,
+			Message: fmt.Sprintf("[%s] method [%s] not found!", m.name, method),
+		}
+	}
+
+	params, err := f.params(ctx, args)
+	if nil != err {
+		return nil, &Error{
+			Kind:    KindBadArgs,
+			Message: fmt.Sprintf("[%s] method [%s] %s!", m.name, method, err),
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = &Error{
+				Kind:    KindPanic,
+				Message: fmt.Sprintf("[%s] method [%s] panic: %v!", m.name, method, r),
+				Stack:   string(debug.Stack()),
+			}
+		}
+	}()
+	ret := f.fn.Call(params)
+
+	result = make([]interface{}, len(ret))
+	for i, r := range ret {
+		result[i] = r.Interface()
+	}
+
+	return result, nil
+}
+
+// params converts args to the parameter types of the function, and ctx is
+// the first one if the function takes context.Context first.
+func (f *fcall) params(ctx context.Context, args []interface{}) ([]reflect.Value, error) {
+	params := make([]reflect.Value, 0, len(args)+1)
+	offset := 0
+	if f.ft.NumIn() > 0 && contextType == f.ft.In(0) {
+		if nil == ctx {
+			ctx = context.Background()
 		}
-		ret := f.fn.Call(params)
+		params = append(params, reflect.ValueOf(ctx))
+		offset = 1
+	}
+
+	n := f.ft.NumIn() - offset
+	if f.ft.IsVariadic() {
+		if len(args) < n-1 {
+			return nil, fmt.Errorf("expects at least %d args but got %d", n-1, len(args))
+		}
+	} else if len(args) != n {
+		return nil, fmt.Errorf("expects %d args but got %d", n, len(args))
+	}
 
-		result := make([]interface{}, len(ret))
-		for i, r := range ret {
-			result[i] = r.Interface()
+	for i, arg := range args {
+		var t reflect.Type
+		if f.ft.IsVariadic() && i >= n-1 {
+			t = f.ft.In(offset + n - 1).Elem()
+		} else {
+			t = f.ft.In(offset + i)
 		}
 
-		return result
+		v, ok := convert(arg, t)
+		if !ok {
+			return nil, fmt.Errorf("expects %s for arg %d but got %T", t, i, arg)
+		}
+		params = append(params, v)
+	}
+
+	return params, nil
+}
+
+// convert returns arg as type t. Numbers are not converted to string as
+// reflect does.
+func convert(arg interface{}, t reflect.Type) (reflect.Value, bool) {
+	if nil == arg {
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(t), true
+		}
+		return reflect.Value{}, false
+	}
+
+	v := reflect.ValueOf(arg)
+	if !v.Type().ConvertibleTo(t) {
+		return reflect.Value{}, false
+	}
+	if reflect.String == t.Kind() && reflect.String != v.Kind() && reflect.Slice != v.Kind() {
+		return reflect.Value{}, false
 	}
 
-	return nil
+	return v.Convert(t), true
 }
 
 func (m *meta) timeout(method string) float32 {
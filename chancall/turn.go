@@ -0,0 +1,124 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package chancall
+
+import (
+	"fmt"
+	"sync"
+)
+
+type turnKey struct{}
+
+func newTurn() *turn {
+	return &turn{
+		yield:  make(chan struct{}),
+		resume: make(chan struct{}),
+	}
+}
+
+// turn is the routine of callee lent to the method serving a stream. The
+// method holds it while running, and gives it up while blocked, so other
+// calls go on in between but never along with the method.
+type turn struct {
+	sync.Mutex
+	callee  *callee
+	holding bool          // the method is running with the routine.
+	over    bool          // the method returns.
+	yield   chan struct{} // the method gives up the routine.
+	resume  chan struct{} // the method gets the routine back.
+}
+
+// lend lets the method of request run with the routine of callee, and waits
+// until the method gives it up.
+func (t *turn) lend(c *callee, request *callRequest) {
+	t.Lock()
+	t.callee = c
+	t.holding = true
+	t.Unlock()
+
+	go func() {
+		err := c.serve(request)
+		t.finish()
+		if nil != err {
+			panic(fmt.Sprintf("Invoke error %s", err.Error()))
+		}
+	}()
+
+	t.await(t.yield)
+}
+
+// give gives up the routine, and returns false if not holding it.
+func (t *turn) give() bool {
+	t.Lock()
+	holding := t.holding
+	t.holding = false
+	t.Unlock()
+
+	if holding {
+		t.signal(t.yield)
+	}
+	return holding
+}
+
+// take waits for the routine back, and it's queued after the calls coming
+// in meanwhile.
+func (t *turn) take() {
+	t.Lock()
+	over := t.over
+	t.Unlock()
+	if over {
+		return
+	}
+
+	// The method goes on without the routine if callee is closed.
+	select {
+	case t.callee.callRequest <- &callRequest{turn: t}:
+		t.await(t.resume)
+	case <-t.callee.quit:
+	}
+}
+
+// hand passes the routine to the method taking it back, and waits until the
+// method gives it up again.
+func (t *turn) hand() {
+	t.Lock()
+	over := t.over
+	t.holding = !over
+	t.Unlock()
+
+	t.signal(t.resume)
+	if !over {
+		t.await(t.yield)
+	}
+}
+
+// finish gives up the routine after the method returns.
+func (t *turn) finish() {
+	t.Lock()
+	holding := t.holding
+	t.holding = false
+	t.over = true
+	t.Unlock()
+
+	if holding {
+		t.signal(t.yield)
+	}
+}
+
+// signal passes ch to the other side unless callee is closed.
+func (t *turn) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	case <-t.callee.quit:
+	}
+}
+
+// await waits for ch from the other side unless callee is closed.
+func (t *turn) await(ch chan struct{}) {
+	select {
+	case <-ch:
+	case <-t.callee.quit:
+	}
+}
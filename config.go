@@ -0,0 +1,152 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"time"
+)
+
+const (
+	cDefaultHeartbeatInterval    float32 = 1.0
+	cDefaultHeartbeatTimeout     float32 = 5.0
+	cDefaultQueryTimeout         float32 = 10.0
+	cDefaultRestoreTimeout       float32 = 30.0
+	cDefaultReconnectInterval    float32 = 0.5
+	cDefaultReconnectMaxInterval float32 = 30.0
+	cDefaultDrainTimeout         float32 = 5.0
+	cDefaultCallTimeout          float32 = 10.0
+)
+
+// HubConfig contains all settings to run a hub.
+type HubConfig struct {
+	// Addr the hub listens on.
+	Addr string
+
+	// Peers are addrs of other hubs to replicate registry with, and every
+	// hub should list all the others with the same addrs as their Addr.
+	Peers []string
+
+	// BlackPorts will never be allocated to docks.
+	BlackPorts []int
+
+	// HeartbeatTimeout in seconds, a dock is evicted if no heartbeat
+	// arrives during this duration.
+	HeartbeatTimeout float32
+
+	// QueryTimeout in seconds, a slot query is responded with error if no
+	// ready slot shows up during this duration.
+	QueryTimeout float32
+
+	// DisableAllocator stops hub allocating ports, and all docks must bind
+	// and advertise their addrs themselves.
+	DisableAllocator bool
+
+	// Balancers choose instance for slot by id for each call not targeting a
+	// dock, and RoundRobin is used for slots not in the map.
+	Balancers map[string]IBalancer
+
+	// Snapshot is the file to persist registry for recovery after restart,
+	// and empty means no persistence.
+	Snapshot string
+
+	// RestoreTimeout in seconds, the docks restored from snapshot are removed
+	// if they don't register again during this duration.
+	RestoreTimeout float32
+
+	// Secret shared by docks and hubs to authenticate, and empty means no
+	// authentication if Tokens is empty too.
+	Secret string
+
+	// Tokens are secrets of docks by dock name, and Secret is used for the
+	// docks not in the map. Peer hubs are named by their addrs.
+	Tokens map[string]string
+
+	// PublicInspect allows the peers not authenticated to inspect docks when
+	// authentication is enabled.
+	PublicInspect bool
+}
+
+func (c *HubConfig) normalize() {
+	if c.HeartbeatTimeout <= 0 {
+		c.HeartbeatTimeout = cDefaultHeartbeatTimeout
+	}
+	if c.QueryTimeout <= 0 {
+		c.QueryTimeout = cDefaultQueryTimeout
+	}
+	if c.RestoreTimeout <= 0 {
+		c.RestoreTimeout = cDefaultRestoreTimeout
+	}
+}
+
+// DockConfig contains all settings to run a dock.
+type DockConfig struct {
+	// HubAddr is the address of the hub to register to, and multiple hubs
+	// are separated by comma for failover.
+	HubAddr string
+
+	// Name of the dock for tracking.
+	Name string
+
+	// HeartbeatInterval in seconds between two heartbeats sent to hub.
+	HeartbeatInterval float32
+
+	// ListenAddr for other docks to connect, like "0.0.0.0:0" for an
+	// ephemeral port. Empty means listening on the port allocated by hub.
+	ListenAddr string
+
+	// AdvertiseAddr is the addr told to hub for other docks to connect, like
+	// "10.0.0.5:7000". Host is filled by hub if empty, and port is the
+	// listening port if empty or zero.
+	AdvertiseAddr string
+
+	// Secret to authenticate to hub and other docks, and also to check other
+	// docks both connecting in and dialed. Dock waits for challenge if having
+	// secret, so hub must have authentication enabled too.
+	Secret string
+
+	// Tokens are secrets of other docks by dock name to check them, and
+	// Secret is used for the docks not in the map.
+	Tokens map[string]string
+
+	// ReconnectInterval in seconds to wait before connecting to hub again,
+	// and it doubles after each failure.
+	ReconnectInterval float32
+
+	// ReconnectMaxInterval in seconds is the limit of ReconnectInterval.
+	ReconnectMaxInterval float32
+
+	// DrainTimeout in seconds to wait for the calls in progress when dock is
+	// closing or a slot is uncarried.
+	DrainTimeout float32
+
+	// CallTimeout in seconds to wait for the response of remote calls,
+	// including the time to find the target slot.
+	CallTimeout float32
+}
+
+func (c *DockConfig) normalize() {
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = cDefaultHeartbeatInterval
+	}
+	if c.ReconnectInterval <= 0 {
+		c.ReconnectInterval = cDefaultReconnectInterval
+	}
+	if c.ReconnectMaxInterval <= 0 {
+		c.ReconnectMaxInterval = cDefaultReconnectMaxInterval
+	}
+	if c.ReconnectMaxInterval < c.ReconnectInterval {
+		c.ReconnectMaxInterval = c.ReconnectInterval
+	}
+	if c.DrainTimeout <= 0 {
+		c.DrainTimeout = cDefaultDrainTimeout
+	}
+	if c.CallTimeout <= 0 {
+		c.CallTimeout = cDefaultCallTimeout
+	}
+}
+
+func seconds(v float32) time.Duration {
+	return time.Duration(float64(v) * float64(time.Second))
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"context"
+	"time"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+// inbound identifies the call in progress from other dock.
+type inbound struct {
+	peer  network.IPeer
+	index int64
+}
+
+// hold returns the context of the call from the dock at peer, which is done
+// when the deadline of caller passes or the caller cancels it.
+func (d *dock) hold(peer network.IPeer, req *protoRpcRequest) context.Context {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if req.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(req.Deadline))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	d.callsMutex.Lock()
+	defer d.callsMutex.Unlock()
+
+	d.calls[inbound{peer: peer, index: req.Index}] = cancel
+
+	return ctx
+}
+
+// release forgets the call completed.
+func (d *dock) release(peer network.IPeer, index int64) {
+	d.callsMutex.Lock()
+	defer d.callsMutex.Unlock()
+
+	key := inbound{peer: peer, index: index}
+	if cancel, ok := d.calls[key]; ok {
+		cancel()
+		delete(d.calls, key)
+	}
+}
+
+// abandon cancels the calls from the dock at peer, and all of them if index
+// is negative.
+func (d *dock) abandon(peer network.IPeer, index int64) {
+	d.callsMutex.Lock()
+	defer d.callsMutex.Unlock()
+
+	for key, cancel := range d.calls {
+		if key.peer == peer && (index < 0 || key.index == index) {
+			cancel()
+			delete(d.calls, key)
+		}
+	}
+}
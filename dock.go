@@ -5,22 +5,47 @@
 package ferry
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/muguangyi/ferry/chancall"
 	"github.com/muguangyi/ferry/network"
 )
 
-func newDock(name string, slots ...ISlot) *dock {
+func newDock(conf DockConfig, slots ...ISlot) *dock {
+	conf.normalize()
+
 	d := new(dock)
-	d.name = name
-	d.sockets = make([]network.ISocket, 0)
+	d.conf = conf
+	d.name = conf.Name
+	d.id = randomHex(cIdSize)
+	for _, addr := range strings.Split(conf.HubAddr, ",") {
+		if addr = strings.TrimSpace(addr); "" != addr {
+			d.hubAddrs = append(d.hubAddrs, addr)
+		}
+	}
+	d.sockets = make(map[string]network.ISocket)
+	d.links = make(map[string]network.IPeer)
 	d.slots = make(map[string]*slot)
+	d.gone = make(map[string]bool)
 	d.remoteSlots = make(map[string]network.IPeer)
 	d.rpcs = make(map[int64]*rpc)
+	d.calls = make(map[inbound]context.CancelFunc)
+	d.streams = make(map[inbound]*stream)
+	d.lists = make(map[int64]chan *protoListResponse)
+	d.topics = make(map[string]*feed)
+	d.quotas = make(map[string]chan struct{})
+	d.closeSig = make(chan bool, 1)
+	d.guard = newGuard(conf.Secret, conf.Tokens)
+	d.watchers = make(map[string][]*watcher)
+	d.sights = make(map[string]map[string]bool)
+	d.stale = make(map[string]map[string]bool)
 
 	for _, v := range slots {
 		s := v.(*slot)
@@ -32,24 +57,86 @@ func newDock(name string, slots ...ISlot) *dock {
 }
 
 type dock struct {
+	conf             DockConfig
 	name             string
-	sockets          []network.ISocket // socket at index 0 is hub.
+	id               string // unique id of the dock instance.
+	hubAddrs         []string
+	hubMutex         sync.Mutex
+	addr             string // advertised addr for other docks to connect.
+	hubIndex         int
+	hubSocket        network.ISocket
+	hub              network.IPeer
+	server           network.ISocket // listening for other docks.
+	linksMutex       sync.Mutex
+	sockets          map[string]network.ISocket // sockets dialed to other docks by addr.
+	links            map[string]network.IPeer   // connections to other docks by addr.
+	slotsMutex       sync.Mutex
+	started          bool
 	slots            map[string]*slot
+	gone             map[string]bool // slots uncarried, whose calls could be retried.
 	remoteSlotsMutex sync.Mutex
 	remoteSlots      map[string]network.IPeer
+	rpcsMutex        sync.Mutex
 	rpcs             map[int64]*rpc
+	servingMutex     sync.Mutex
+	serving          sync.WaitGroup // incoming calls in progress.
+	callsMutex       sync.Mutex
+	calls            map[inbound]context.CancelFunc // cancels of incoming calls.
+	streamsMutex     sync.Mutex
+	streams          map[inbound]*stream // streams opened by this dock have no peer.
+	listsMutex       sync.Mutex
+	lists            map[int64]chan *protoListResponse // broadcasts waiting for hub.
+	topicsMutex      sync.Mutex
+	topics           map[string]*feed
+	quotas           map[string]chan struct{} // credits to publish topics.
+	draining         bool
+	guard            *guard
+	watchersMutex    sync.Mutex
+	watchers         map[string][]*watcher
+	sights           map[string]map[string]bool // known instances of watched slots.
+	stale            map[string]map[string]bool // instances known before failover.
+	closeSig         chan bool
 }
 
 func (d *dock) Close() {
-	for _, s := range d.slots {
+	d.drain()
+	close(d.closeSig)
+
+	d.slotsMutex.Lock()
+	slots := d.slots
+	d.slots = nil
+	d.slotsMutex.Unlock()
+	for _, s := range slots {
 		s.feature.OnDestroy(s)
+		s.callee.Close()
 	}
-	d.slots = nil
+	d.blind()
+	d.hush()
 
-	for i := len(d.sockets) - 1; i >= 0; i-- {
-		d.sockets[i].Close()
+	d.linksMutex.Lock()
+	sockets := make([]network.ISocket, 0, len(d.sockets))
+	for _, socket := range d.sockets {
+		sockets = append(sockets, socket)
 	}
-	d.sockets = d.sockets[:0]
+	d.linksMutex.Unlock()
+	for _, socket := range sockets {
+		socket.Close()
+	}
+
+	d.hubMutex.Lock()
+	hubSocket := d.hubSocket
+	d.hubSocket = nil
+	server := d.server
+	d.server = nil
+	d.hubMutex.Unlock()
+	if nil != hubSocket {
+		hubSocket.Close()
+	}
+	if nil != server {
+		server.Close()
+	}
+
+	d.abort()
 }
 
 func (d *dock) OnConnected(peer network.IPeer) {
@@ -57,39 +144,121 @@ func (d *dock) OnConnected(peer network.IPeer) {
 		log.Printf("[%s] connected to [%s].", peer.LocalAddr(), peer.RemoteAddr())
 	}
 
-	// Register Dock itself to the remote Server (Hub/Dock).
-	go func() {
-		req := &packer{
-			Id: cRegisterRequest,
-			P: &protoRegisterRequest{
-				Slots: d.collect(),
-			},
+	// Register Dock itself to the remote Server (Hub/Dock). The docks
+	// connecting in must authenticate first, and dock waits for challenge from
+	// the remote server if having secret.
+	if peer.IsSelf() {
+		if "" == d.conf.Secret {
+			go d.register(peer)
 		}
-		peer.Send(req)
-	}()
+	} else if d.guard.enabled() {
+		d.guard.challenge(peer)
+	} else {
+		go d.register(peer)
+	}
 }
 
 func (d *dock) OnClosed(peer network.IPeer) {
+	d.guard.forget(peer)
+	d.abandon(peer, -1)
+	d.sever(peer)
+
+	// Forget all slots hosted by the closed peer.
+	d.unbind(peer)
+	d.remoteSlotsMutex.Lock()
+	for id, p := range d.remoteSlots {
+		if p == peer {
+			delete(d.remoteSlots, id)
+		}
+	}
+	d.remoteSlotsMutex.Unlock()
+
+	// Connect to hub again or switch to other hub if there is any, and the
+	// calls waiting for query result will be dispatched again after
+	// registered.
+	lost := d.lose(peer)
+	failover := lost && !d.closing()
+	if failover {
+		go d.failover()
+	}
+
+	// Fail all calls still waiting on the closed peer, including the ones
+	// waiting for query result if hub is gone.
+	d.rpcsMutex.Lock()
+	broken := make([]*rpc, 0)
+	for index, r := range d.rpcs {
+		if r.peer == peer || (nil == r.peer && lost && !failover) {
+			broken = append(broken, r)
+			delete(d.rpcs, index)
+		}
+	}
+	d.rpcsMutex.Unlock()
+
+	for _, r := range broken {
+		r.callback(&ret{
+			err: newError(CodeUnavailable, "[%s] remote dock [%s] disconnected!", r.key(), peer.RemoteAddr()),
+		})
+	}
 }
 
 func (d *dock) OnPacket(peer network.IPeer, obj interface{}) {
 	pack := obj.(*packer)
+
+	// Only auth answer is accepted from the docks connecting in until
+	// authenticated.
+	if !peer.IsSelf() && d.guard.enabled() && !d.guard.passed(peer) {
+		if cAuth == pack.Id && d.guard.verify(peer, pack.P.(*protoAuth)) {
+			go d.register(peer)
+		} else {
+			log.Printf("[%s] authentication failed.", peer.RemoteAddr())
+			d.guard.reject(peer)
+		}
+		return
+	}
+
 	switch pack.Id {
+	case cChallenge:
+		{
+			// The docks connecting in are registered already, and only
+			// answer the challenge of the hub. The dialed docks are
+			// handled by bridge.
+			answer(peer, d.name, d.conf.Secret, pack.P.(*protoChallenge))
+			if peer.IsSelf() {
+				go d.register(peer)
+			}
+		}
 	case cError:
 		{
+			resp := pack.P.(*protoError)
+			if "" == resp.Slot {
+				log.Printf("[%s] error from [%s]: %s", peer.LocalAddr(), peer.RemoteAddr(), resp.Error)
+				return
+			}
 
+			// Fail all calls waiting for the slot which can't be found.
+			d.rpcsMutex.Lock()
+			failed := make([]*rpc, 0)
+			for index, r := range d.rpcs {
+				if nil == r.peer && r.key() == resp.Slot {
+					failed = append(failed, r)
+					delete(d.rpcs, index)
+				}
+			}
+			d.rpcsMutex.Unlock()
+
+			for _, r := range failed {
+				r.callback(&ret{err: &Error{Code: CodeNotFound, Message: resp.Error}})
+			}
 		}
 	case cReady:
 		{
-			// Check if there is a RPC waiting for this Dock.
+			// Route the calls to the slots of the dialed dock through the
+			// link, and check if there is a RPC waiting for this Dock.
 			resp := pack.P.(*protoReady)
-			for _, slot := range resp.Slots {
-				for _, r := range d.rpcs {
-					if r.req != nil && r.req.Slot == slot {
-						d.commit(r)
-					}
-				}
+			if d.linked(peer) {
+				d.learn(peer, resp.Slots, resp.Name, resp.Id)
 			}
+			d.resume(resp.Slots)
 		}
 	// Handle Dock RegisterRequest.
 	case cRegisterRequest:
@@ -99,112 +268,420 @@ func (d *dock) OnPacket(peer network.IPeer, obj interface{}) {
 				Id: cReady,
 				P: &protoReady{
 					Slots: d.collect(),
+					Name:  d.name,
+					Id:    d.id,
 				},
 			}
 			peer.Send(resp)
 
-			// Cache in-connect Dock info.
+			// Cache in-connect Dock info, and share the connection for the
+			// calls in both directions.
 			req := pack.P.(*protoRegisterRequest)
-			for _, v := range req.Slots {
-				d.remoteSlotsMutex.Lock()
-				d.remoteSlots[v] = peer
-				d.remoteSlotsMutex.Unlock()
-			}
+			d.learn(d.bind(req.Addr, peer), req.Slots, req.Name, req.Id)
 		}
 	// Handle Hub response for RegisterRquest.
 	case cRegisterResponse:
 		{
-			// Get the port that Hub alloced and start to listen as a server.
+			// Get the port that Hub alloced and start to listen as a server
+			// if not listening yet.
 			resp := pack.P.(*protoRegisterResponse)
-			listenAddr := fmt.Sprintf("0.0.0.0:%d", resp.Port)
-			socket := network.NewSocket(listenAddr, "ferry", d)
-			socket.Listen()
-			d.sockets = append(d.sockets, socket)
+			d.hubMutex.Lock()
+			d.hub = peer
+			d.addr = resp.Addr
+			d.hubMutex.Unlock()
+			d.listen(fmt.Sprintf("0.0.0.0:%d", resp.Port))
 
-			// Send DockReadyRequest to Hub.
-			peer.Send(&packer{
-				Id: cReady,
-				P: &protoReady{
-					Slots: d.collect(),
-				},
-			})
+			// Send DockReadyRequest to Hub, or keep draining if registered
+			// again during closing.
+			if d.refusing() {
+				peer.Send(&packer{
+					Id: cDrain,
+					P:  &protoDrain{},
+				})
+			} else {
+				peer.Send(&packer{
+					Id: cReady,
+					P: &protoReady{
+						Slots: d.collect(),
+					},
+				})
+			}
+
+			d.rewatch(peer)
+			d.resubscribe(peer)
+			go d.heartbeat(peer)
 
-			go d.start()
+			// Features keep running when registered again after failover,
+			// but the pending queries need to be sent to the new hub.
+			if launched, slots := d.launch(); launched {
+				go d.start(slots)
+			} else {
+				d.redispatch()
+			}
+		}
+	case cDeregister:
+		{
+			d.forget(peer, pack.P.(*protoDeregister))
+		}
+	case cSlotEvent:
+		{
+			d.spread(pack.P.(*protoSlotEvent))
+		}
+	case cPublish:
+		{
+			d.deliver(pack.P.(*protoPublish))
+		}
+	case cPublishCredit:
+		{
+			d.credited(pack.P.(*protoPublishCredit))
+		}
+	case cListResponse:
+		{
+			d.listed(pack.P.(*protoListResponse))
 		}
 	case cQueryResponse:
 		{
 			resp := pack.P.(*protoQueryResponse)
-			socket := network.NewSocket(resp.DockAddr, "ferry", d)
-			socket.Dial()
-			d.sockets = append(d.sockets, socket)
+			target := resp.Dock
+			d.rpcsMutex.Lock()
+			if r, ok := d.rpcs[resp.Index]; ok {
+				r.querying = false
+				if "" == r.dock {
+					r.via = resp.DockId
+					target = resp.DockId
+				}
+			}
+			d.rpcsMutex.Unlock()
+			d.reach(resp.Slot, target, resp.DockAddr)
 		}
 	case cRpcRequest:
 		{
 			req := pack.P.(*protoRpcRequest)
-			target := d.slots[req.Slot]
-			if nil != target {
-				go func() {
-					caller := chancall.NewCaller(target.callee)
-					var result []interface{}
-					var err error
-					if req.WithResult {
-						result, err = caller.CallWithResult(req.Method, req.Args...)
-					} else {
-						err = caller.Call(req.Method, req.Args...)
-					}
-
-					resp := &packer{
-						Id: cRpcResponse,
-						P: &protoRpcResponse{
-							Index:  req.Index,
-							Slot:   req.Slot,
-							Method: req.Method,
-							Result: result,
-							Err: func() string {
-								if nil != err {
-									return err.Error()
-								}
-
-								return ""
-							}(),
-						},
-					}
-					peer.Send(resp)
-				}()
+			target := d.find(req.Slot)
+			if nil == target {
+				if d.left(req.Slot) {
+					refuse(peer, req, newError(CodeUnavailable, "[%s] slot is uncarried!", req.Slot), true)
+				} else {
+					refuse(peer, req, newError(CodeNotFound, "[%s] slot not found!", req.Slot), false)
+				}
+				return
+			}
+			if err := d.admit(peer, target, req.Method, req.Caller); nil != err {
+				refuse(peer, req, err, false)
+				return
 			}
+			if !d.serve() {
+				refuse(peer, req, newError(CodeUnavailable, "[%s] dock is draining!", req.Slot), true)
+				return
+			}
+			if !target.enter() {
+				d.serving.Done()
+				refuse(peer, req, newError(CodeUnavailable, "[%s] slot is uncarried!", req.Slot), true)
+				return
+			}
+			if req.Stream {
+				d.serveStream(peer, target, req)
+				return
+			}
+
+			ctx := d.hold(peer, req)
+			go func() {
+				defer d.serving.Done()
+				defer target.exit()
+				defer d.release(peer, req.Index)
+
+				caller := chancall.NewCaller(target.callee)
+				var result []interface{}
+				var err error
+				if req.WithResult {
+					result, err = settle(caller.CallWithResultContext(ctx, req.Method, req.Args...))
+				} else {
+					err = caller.CallContext(ctx, req.Method, req.Args...)
+				}
+
+				resp := &protoRpcResponse{
+					Index:  req.Index,
+					Slot:   req.Slot,
+					Method: req.Method,
+					Result: result,
+				}
+				if nil != err {
+					resp.Err, resp.Code, resp.Details = flatten(err)
+				}
+				peer.Send(&packer{
+					Id: cRpcResponse,
+					P:  resp,
+				})
+			}()
+		}
+	case cStreamData:
+		{
+			p := pack.P.(*protoStreamData)
+			d.relay(peer, p.Index, p.Callee, pack)
+		}
+	case cStreamCredit:
+		{
+			p := pack.P.(*protoStreamCredit)
+			d.relay(peer, p.Index, p.Callee, pack)
+		}
+	case cStreamEnd:
+		{
+			p := pack.P.(*protoStreamEnd)
+			d.relay(peer, p.Index, p.Callee, pack)
+		}
+	case cRpcCancel:
+		{
+			d.abandon(peer, pack.P.(*protoRpcCancel).Index)
+		}
+	case cRpcNotify:
+		{
+			d.notified(peer, pack.P.(*protoRpcNotify))
 		}
 	case cRpcResponse:
 		{
 			resp := pack.P.(*protoRpcResponse)
+			d.rpcsMutex.Lock()
 			rpc := d.rpcs[resp.Index]
+			if nil != rpc && resp.Retry && rpc.retries < cMaxRetries {
+				d.retry(rpc, peer)
+				d.rpcsMutex.Unlock()
+				return
+			}
+			delete(d.rpcs, resp.Index)
+			d.rpcsMutex.Unlock()
 			if nil != rpc {
-				go func() {
-					rpc.callback(&ret{
-						result: resp.Result,
-						err: func() error {
-							if "" != resp.Err {
-								return errors.New(resp.Err)
-							}
-
-							return nil
-						}(),
-					})
-					delete(d.rpcs, resp.Index)
-				}()
+				rpc.callback(&ret{
+					result: resp.Result,
+					err:    rebuild(resp.Err, resp.Code, resp.Details),
+				})
 			}
 		}
 	}
 }
 
-func (d *dock) run(hubAddr string) {
+func (d *dock) run() {
 	network.ExtendSerializer("ferry", newSerializer())
 
-	socket := network.NewSocket(hubAddr, "ferry", d)
-	socket.Dial()
-	d.sockets = append(d.sockets, socket)
+	// Bind the port before registering if dock decides the addr itself.
+	if "" != d.conf.ListenAddr || "" != d.conf.AdvertiseAddr {
+		listenAddr := d.conf.ListenAddr
+		if "" == listenAddr {
+			listenAddr = fmt.Sprintf("0.0.0.0:%d", pickPort(d.conf.AdvertiseAddr))
+		}
+		d.listen(listenAddr)
+
+		addr := d.advertise()
+		d.hubMutex.Lock()
+		d.addr = addr
+		d.hubMutex.Unlock()
+	}
+
+	if !d.connect() {
+		log.Printf("Can't connect to any hub of [%s], retrying...", d.conf.HubAddr)
+		go d.reconnect()
+	}
+}
+
+// connect tries all hubs in turn from the current one until connected.
+func (d *dock) connect() bool {
+	for i := 0; i < len(d.hubAddrs); i++ {
+		d.hubMutex.Lock()
+		addr := d.hubAddrs[d.hubIndex]
+		d.hubMutex.Unlock()
+
+		socket := network.NewSocket(addr, "ferry", d)
+		d.hubMutex.Lock()
+		d.hubSocket = socket
+		d.hubMutex.Unlock()
+
+		err := socket.Dial()
+		if nil == err {
+			if d.closing() {
+				socket.Close()
+			}
+			return true
+		}
+		log.Printf("Connect to hub [%s] failed: %s", addr, err)
+
+		d.hubMutex.Lock()
+		d.hubIndex = (d.hubIndex + 1) % len(d.hubAddrs)
+		d.hubMutex.Unlock()
+	}
+
+	return false
+}
+
+// failover switches to the next available hub.
+func (d *dock) failover() {
+	d.hubMutex.Lock()
+	d.hubIndex = (d.hubIndex + 1) % len(d.hubAddrs)
+	d.hubMutex.Unlock()
+
+	if !d.connect() {
+		d.reconnect()
+	}
+}
+
+// reconnect keeps connecting to hubs with exponential backoff until connected
+// or dock closed.
+func (d *dock) reconnect() {
+	interval := seconds(d.conf.ReconnectInterval)
+	for {
+		select {
+		case <-d.closeSig:
+			return
+		case <-time.After(interval):
+		}
+
+		if d.connect() {
+			return
+		}
+
+		interval *= 2
+		if max := seconds(d.conf.ReconnectMaxInterval); interval > max {
+			interval = max
+		}
+	}
+}
+
+// lose forgets the hub if peer is the hub and returns true.
+func (d *dock) lose(peer network.IPeer) bool {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	if nil != d.hub && d.hub == peer {
+		d.hub = nil
+		return true
+	}
+
+	return false
+}
+
+func (d *dock) currentHub() network.IPeer {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.hub
+}
+
+// drain stops routing and serving new calls to this dock, and waits for the
+// calls in progress until DrainTimeout.
+func (d *dock) drain() {
+	d.servingMutex.Lock()
+	d.draining = true
+	d.servingMutex.Unlock()
+
+	if hub := d.currentHub(); nil != hub {
+		hub.Send(&packer{
+			Id: cDrain,
+			P:  &protoDrain{},
+		})
+	}
+
+	done := make(chan bool)
+	go func() {
+		d.serving.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(seconds(d.conf.DrainTimeout)):
+		log.Printf("[%s] dock drain timeout, calls in progress are dropped.", d.address())
+	}
+}
+
+// serve counts an incoming call in progress, and returns false if dock is
+// draining.
+func (d *dock) serve() bool {
+	d.servingMutex.Lock()
+	defer d.servingMutex.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.serving.Add(1)
+
+	return true
+}
+
+func (d *dock) refusing() bool {
+	d.servingMutex.Lock()
+	defer d.servingMutex.Unlock()
+
+	return d.draining
+}
+
+func (d *dock) closing() bool {
+	select {
+	case <-d.closeSig:
+		return true
+	default:
+		return false
+	}
+}
+
+// listen starts serving other docks at addr if not listening yet.
+func (d *dock) listen(addr string) {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	if nil != d.server || d.closing() {
+		return
+	}
+	d.server = network.NewSocket(addr, "ferry", d)
+	d.server.Listen()
+}
+
+// address returns the addr advertised to hub, which is empty until the dock
+// listens.
+func (d *dock) address() string {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.addr
+}
+
+// advertise returns the addr for hub to tell other docks, and empty host will
+// be filled by hub.
+func (d *dock) advertise() string {
+	d.hubMutex.Lock()
+	server := d.server
+	d.hubMutex.Unlock()
+
+	port := strconv.Itoa(pickPort(server.Addr().String()))
+	if "" == d.conf.AdvertiseAddr {
+		return net.JoinHostPort("", port)
+	}
+
+	host, p, err := net.SplitHostPort(d.conf.AdvertiseAddr)
+	if nil != err {
+		return d.conf.AdvertiseAddr
+	}
+	if "" == p || "0" == p {
+		p = port
+	}
+
+	return net.JoinHostPort(host, p)
+}
+
+// register tells the remote hub or dock all discoverable slots of this dock.
+func (d *dock) register(peer network.IPeer) {
+	peer.Send(&packer{
+		Id: cRegisterRequest,
+		P: &protoRegisterRequest{
+			Slots: d.collect(),
+			Addr:  d.address(),
+			Name:  d.name,
+			Id:    d.id,
+		},
+	})
 }
 
 func (d *dock) collect() []string {
+	d.slotsMutex.Lock()
+	defer d.slotsMutex.Unlock()
+
 	ids := make([]string, 0)
 	for id, v := range d.slots {
 		if v.discoverable {
@@ -215,51 +692,257 @@ func (d *dock) collect() []string {
 	return ids
 }
 
-func (d *dock) start() {
-	for _, s := range d.slots {
+// heartbeat keeps telling hub this dock is alive until dock closed.
+func (d *dock) heartbeat(hub network.IPeer) {
+	ticker := time.NewTicker(seconds(d.conf.HeartbeatInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closeSig:
+			return
+		case <-ticker.C:
+			if hub != d.currentHub() {
+				return
+			}
+			hub.Send(&packer{
+				Id: cHeartbeat,
+				P:  &protoHeartbeat{},
+			})
+		}
+	}
+}
+
+func (d *dock) start(slots []*slot) {
+	for _, s := range slots {
 		s.feature.OnStart(s)
 	}
 }
 
-func (d *dock) call(name string, method string, args ...interface{}) error {
-	target := d.slots[name]
-	if nil != target {
-		return chancall.NewCaller(target.callee).Call(method, args...)
+func (d *dock) call(ctx context.Context, caller string, name string, method string, args ...interface{}) error {
+	target := d.local(name)
+	if nil != target && target.enter() {
+		defer target.exit()
+		if !target.permit(method, d.name, caller) {
+			return denied(target, method)
+		}
+		return classify(chancall.NewCaller(target.callee).CallContext(ctx, method, args...))
 	} else {
-		rpc := newRpc()
-		d.rpcs[rpc.index] = rpc
-		return rpc.call(d, name, method, args...)
+		return newRpc().call(ctx, d, caller, name, method, args...)
 	}
 }
 
-func (d *dock) callWithResult(name string, method string, args ...interface{}) ([]interface{}, error) {
-	target := d.slots[name]
-	if nil != target {
-		return chancall.NewCaller(target.callee).CallWithResult(method, args...)
+func (d *dock) callWithResult(ctx context.Context, caller string, name string, method string, args ...interface{}) ([]interface{}, error) {
+	target := d.local(name)
+	if nil != target && target.enter() {
+		defer target.exit()
+		if !target.permit(method, d.name, caller) {
+			return nil, denied(target, method)
+		}
+		result, err := settle(chancall.NewCaller(target.callee).CallWithResultContext(ctx, method, args...))
+		return result, classify(err)
 	} else {
-		rpc := newRpc()
-		d.rpcs[rpc.index] = rpc
-		return rpc.callWithResult(d, name, method, args...)
+		return newRpc().callWithResult(ctx, d, caller, name, method, args...)
 	}
 }
 
+// notify calls method of the slot without waiting for it, and errors are
+// only logged.
+func (d *dock) notify(caller string, name string, method string, args ...interface{}) {
+	target := d.local(name)
+	if nil != target && target.enter() {
+		if !target.permit(method, d.name, caller) {
+			target.exit()
+			log.Println(denied(target, method))
+			return
+		}
+		go func() {
+			defer target.exit()
+
+			if err := chancall.NewCaller(target.callee).Call(method, args...); nil != err {
+				log.Println(err)
+			}
+		}()
+	} else {
+		newRpc().notify(d, caller, name, method, args...)
+	}
+}
+
+// notified calls the slot for the notify from the dock at peer.
+func (d *dock) notified(peer network.IPeer, req *protoRpcNotify) {
+	target := d.find(req.Slot)
+	if nil == target {
+		log.Printf("[%s] notify dropped as slot not found!", req.Slot)
+		return
+	}
+	if err := d.admit(peer, target, req.Method, req.Caller); nil != err {
+		log.Println(err)
+		return
+	}
+	if !d.serve() {
+		log.Printf("[%s] notify dropped as dock is draining!", req.Slot)
+		return
+	}
+	if !target.enter() {
+		d.serving.Done()
+		log.Printf("[%s] notify dropped as slot is uncarried!", req.Slot)
+		return
+	}
+
+	go func() {
+		defer d.serving.Done()
+		defer target.exit()
+
+		if err := chancall.NewCaller(target.callee).Call(req.Method, req.Args...); nil != err {
+			log.Println(err)
+		}
+	}()
+}
+
+// local returns the slot carried by this dock for the call target, which is
+// slot id optionally followed by "@" and the name or id of the dock.
+func (d *dock) local(name string) *slot {
+	slot, dock := splitTarget(name)
+	if "" != dock && dock != d.name && dock != d.id {
+		return nil
+	}
+
+	return d.find(slot)
+}
+
+// commit tracks the rpc until it is responded, and dispatch it.
 func (d *dock) commit(rpc *rpc) {
+	d.rpcsMutex.Lock()
+	defer d.rpcsMutex.Unlock()
+
+	d.rpcs[rpc.index] = rpc
+	d.dispatch(rpc)
+}
+
+// dispatch sends the rpc request to the remote dock which hosts the target
+// slot, or query hub to find it out. Hub is queried for each rpc targeting any
+// dock, so the balancer picks the dock per call. The one-way rpc is done once
+// sent. It must be called with rpcsMutex locked.
+func (d *dock) dispatch(rpc *rpc) {
 	d.remoteSlotsMutex.Lock()
 	defer d.remoteSlotsMutex.Unlock()
 
-	peer, ok := d.remoteSlots[rpc.req.Slot]
-	if ok {
+	peer, ok := d.remoteSlots[rpc.route()]
+	if ok && rpc.oneway {
+		peer.Send(&packer{
+			Id: cRpcNotify,
+			P: &protoRpcNotify{
+				Slot:   rpc.req.Slot,
+				Method: rpc.req.Method,
+				Args:   rpc.req.Args,
+				Caller: rpc.req.Caller,
+			},
+		})
+		delete(d.rpcs, rpc.index)
+	} else if ok {
 		peer.Send(&packer{
 			Id: cRpcRequest,
 			P:  rpc.req,
 		})
-		rpc.req = nil
-	} else {
-		d.sockets[0].Send(&packer{
+		rpc.peer = peer
+	} else if !rpc.querying {
+		hub := d.currentHub()
+		if nil == hub {
+			// Will be sent after connected to hub.
+			return
+		}
+		rpc.querying = true
+		hub.Send(&packer{
 			Id: cQueryRequest,
 			P: &protoQueryRequest{
-				Slot: rpc.req.Slot,
+				Slot:  rpc.req.Slot,
+				Dock:  rpc.dock,
+				Index: rpc.index,
 			},
 		})
 	}
 }
+
+// retry sends the rpc refused by the draining dock at peer to other dock. It
+// must be called with rpcsMutex locked.
+func (d *dock) retry(rpc *rpc, peer network.IPeer) {
+	rpc.retries++
+
+	d.remoteSlotsMutex.Lock()
+	if d.remoteSlots[rpc.route()] == peer {
+		delete(d.remoteSlots, rpc.route())
+	}
+	d.remoteSlotsMutex.Unlock()
+
+	rpc.peer = nil
+	rpc.via = ""
+	d.dispatch(rpc)
+}
+
+// cancel stops tracking the rpc and fails it with err, and returns false if
+// it's responded already. The remote dock is told if the request is sent.
+func (d *dock) cancel(rpc *rpc, err error) bool {
+	d.rpcsMutex.Lock()
+	_, ok := d.rpcs[rpc.index]
+	peer := rpc.peer
+	delete(d.rpcs, rpc.index)
+	d.rpcsMutex.Unlock()
+
+	if ok {
+		// Tell the remote dock to stop the call in progress.
+		if nil != peer {
+			peer.Send(&packer{
+				Id: cRpcCancel,
+				P:  &protoRpcCancel{Index: rpc.index},
+			})
+		}
+		rpc.callback(&ret{err: err})
+	}
+
+	return ok
+}
+
+// abort fails all calls still waiting for response.
+func (d *dock) abort() {
+	d.rpcsMutex.Lock()
+	aborted := make([]*rpc, 0, len(d.rpcs))
+	for index, r := range d.rpcs {
+		aborted = append(aborted, r)
+		delete(d.rpcs, index)
+	}
+	d.rpcsMutex.Unlock()
+
+	for _, r := range aborted {
+		r.callback(&ret{
+			err: newError(CodeUnavailable, "[%s] dock closed!", r.key()),
+		})
+	}
+}
+
+// resume dispatches the calls waiting for slots.
+func (d *dock) resume(slots []string) {
+	d.rpcsMutex.Lock()
+	defer d.rpcsMutex.Unlock()
+
+	for _, slot := range slots {
+		for _, r := range d.rpcs {
+			if nil == r.peer && r.req.Slot == slot {
+				d.dispatch(r)
+			}
+		}
+	}
+}
+
+// redispatch sends all calls which are waiting for query result again.
+func (d *dock) redispatch() {
+	d.rpcsMutex.Lock()
+	defer d.rpcsMutex.Unlock()
+
+	for _, r := range d.rpcs {
+		if nil == r.peer {
+			r.querying = false
+			r.via = ""
+			d.dispatch(r)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/muguangyi/ferry/chancall"
+)
+
+// Code tells why a call fails.
+type Code int
+
+const (
+	CodeUnknown     Code = 0x0 // Not classified, like error returned by method
+	CodeNotFound    Code = 0x1 // Slot not found
+	CodeNoMethod    Code = 0x2 // Method not found
+	CodeBadArgs     Code = 0x3 // Args don't match the method
+	CodeDenied      Code = 0x4 // Access to slot or method denied
+	CodeUnavailable Code = 0x5 // Dock draining, closed or disconnected
+	CodeTimeout     Code = 0x6 // Call timeout
+	CodeCanceled    Code = 0x7 // Call canceled
+	CodeInternal    Code = 0x8 // Method panics
+)
+
+// Error of the call, which keeps the code and details from remote dock.
+type Error struct {
+	// Code of the error.
+	Code Code
+
+	// Message of the error.
+	Message string
+
+	// Details of the error, like the stack of the method panicking.
+	Details string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode returns the code of err, and CodeUnknown if it isn't *Error.
+func ErrorCode(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+
+	return CodeUnknown
+}
+
+// structure converts err to *Error to be sent to remote dock.
+func structure(err error) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case *chancall.Error:
+		code := CodeUnknown
+		switch e.Kind {
+		case chancall.KindNotFound:
+			code = CodeNoMethod
+		case chancall.KindBadArgs:
+			code = CodeBadArgs
+		case chancall.KindTimeout:
+			code = CodeTimeout
+		case chancall.KindPanic:
+			code = CodeInternal
+		case chancall.KindClosed:
+			code = CodeUnavailable
+		}
+		return &Error{Code: code, Message: e.Message, Details: e.Stack}
+	}
+
+	switch err {
+	case context.Canceled:
+		return &Error{Code: CodeCanceled, Message: err.Error()}
+	case context.DeadlineExceeded:
+		return &Error{Code: CodeTimeout, Message: err.Error()}
+	}
+
+	return &Error{Code: CodeUnknown, Message: err.Error()}
+}
+
+// classify converts the error of local call to *Error as remote one, but the
+// error of ctx is kept as it is.
+func classify(err error) error {
+	if nil == err || context.Canceled == err || context.DeadlineExceeded == err {
+		return err
+	}
+
+	return structure(err)
+}
+
+// flatten returns the message, code and details of err to be sent.
+func flatten(err error) (string, int, string) {
+	e := structure(err)
+	return e.Message, int(e.Code), e.Details
+}
+
+// rebuild returns the error from the message, code and details received, and
+// nil if message is empty.
+func rebuild(message string, code int, details string) error {
+	if "" == message {
+		return nil
+	}
+
+	return &Error{Code: Code(code), Message: message, Details: details}
+}
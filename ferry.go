@@ -4,6 +4,10 @@
 
 package ferry
 
+import (
+	"context"
+)
+
 // IFeature interface.
 type IFeature interface {
 	// Could start feature logic, like RPC etc.
@@ -18,41 +22,177 @@ type ISlot interface {
 	// Get imported feature visitor.
 	Visit(name string) interface{}
 
-	// Call method with args, and no return value.
+	// Call method with args, and no return value. The name is slot id, or
+	// like "IRoom@room-3" to call the slot on the dock with name or id. The
+	// call fails with *Error telling the code, and the method panicking
+	// fails it with CodeInternal.
 	Call(name string, method string, args ...interface{}) error
 
-	// Call method with args, and has return values.
+	// Call method with args, and has return values. The name is the same as
+	// Call. A non-nil error returned last by the method is returned as the
+	// error instead of a value.
 	CallWithResult(name string, method string, args ...interface{}) ([]interface{}, error)
 
+	// CallContext like Call but gives up when ctx is done, and the remote
+	// call is cancelled too. The deadline of ctx is told to the method which
+	// takes context.Context as first parameter.
+	CallContext(ctx context.Context, name string, method string, args ...interface{}) error
+
+	// CallWithResultContext like CallWithResult but with ctx as CallContext.
+	CallWithResultContext(ctx context.Context, name string, method string, args ...interface{}) ([]interface{}, error)
+
+	// Broadcast calls method of all ready instances of slot id in all docks,
+	// and returns the replies once the gathered ones succeed. The calls left
+	// still go on.
+	Broadcast(ctx context.Context, id string, method string, gather Gather, args ...interface{}) ([]Reply, error)
+
+	// Subscribe topic, and the messages are delivered to method of this slot
+	// in order per publisher.
+	Subscribe(topic string, method string) error
+
+	// Unsubscribe topic.
+	Unsubscribe(topic string) error
+
+	// Publish msg to all subscribers of topic in all docks. It waits if the
+	// subscribers don't keep up, and fails if msg can't be sent to hub.
+	Publish(topic string, msg interface{}) error
+
+	// Stream opens a stream to method, which takes IStream as first parameter
+	// or following context.Context, and the stream ends when it returns. The
+	// name is the same as Call, and ctx cancels the stream.
+	Stream(ctx context.Context, name string, method string, args ...interface{}) (IStream, error)
+
+	// Notify calls method with args in one-way mode, which neither waits nor
+	// gets response, and errors are only logged. The name is the same as
+	// Call.
+	Notify(name string, method string, args ...interface{})
+
+	// Go calls method with args in async mode, and returns the future of
+	// return values. The name is the same as Call.
+	Go(name string, method string, args ...interface{}) IFuture
+
+	// Carry a slot created by Carry func into the running dock, and start it.
+	Carry(target ISlot) error
+
+	// Uncarry the slot with id from the running dock, and destroy it after the
+	// calls in progress are done. New calls are sent to other instances.
+	Uncarry(id string) error
+
+	// Watch slot id and receive its instances added, ready or removed. The
+	// events not received yet are coalesced into the latest of each instance
+	// if the receiver doesn't keep up. The channel is closed when dock closed.
+	Watch(id string) <-chan SlotEvent
+
+	// Unwatch stops the channel returned by Watch, and it's closed.
+	Unwatch(ch <-chan SlotEvent)
+
+	// Allow callers to call method of this slot, and "*" means all methods.
+	// A caller is a dock name, or like "ILogic@logic" for the slot on the
+	// dock. All callers are allowed if method has no allow-list. The docks
+	// calling from remote must be authenticated by the Secret or Tokens of
+	// this dock to match, and the slot of caller is told by the dock itself.
+	Allow(method string, callers ...string)
+
 	// Set target method with timeout duration.
 	SetTimeout(method string, timeout float32)
 }
 
+// IFuture interface is the result of async call.
+type IFuture interface {
+	// Wait until the call completes, and return the return values.
+	Wait() ([]interface{}, error)
+
+	// Wait like Wait but fails if the call doesn't complete in timeout
+	// seconds. The call still goes on after timeout.
+	WaitTimeout(timeout float32) ([]interface{}, error)
+
+	// Done channel is closed when the call completes.
+	Done() <-chan struct{}
+}
+
+// IStream interface is one side of a stream between the caller and the method
+// serving it. Both sides could send, and the sender waits if the other side
+// doesn't keep up. The method gives up its slot while waiting in Send or Recv,
+// so other calls of the slot go on in between but never along with it.
+type IStream interface {
+	// Send value to the other side.
+	Send(v interface{}) error
+
+	// Recv value from the other side, and io.EOF after the other side ends
+	// without error.
+	Recv() (interface{}, error)
+
+	// CloseSend tells the other side no more values.
+	CloseSend() error
+
+	// Close the stream in both directions.
+	Close() error
+
+	// Context of the stream, which is done when the stream is cancelled.
+	Context() context.Context
+}
+
 // Startup run a dock with target hub addr, customize dock name for tracking, and
 // all features running in this dock.
 func Startup(hubAddr string, dockName string, slots ...ISlot) {
-	dock := newDock(dockName, slots...)
-	dock.run(hubAddr)
+	StartupWith(DockConfig{HubAddr: hubAddr, Name: dockName}, slots...)
+}
+
+// StartupWith run a dock with config, and all features running in this dock.
+func StartupWith(conf DockConfig, slots ...ISlot) {
+	dock := newDock(conf, slots...)
+	dock.run()
 	wait(dock)
 }
 
 // Serve run a hub with addr, black list for ports to avoid allocing to docks.
 func Serve(hubAddr string, blackPorts ...int) {
-	hub := newHub()
-	hub.run(hubAddr, blackPorts...)
+	ServeWith(HubConfig{Addr: hubAddr, BlackPorts: blackPorts})
+}
+
+// ServeWith run a hub with config.
+func ServeWith(conf HubConfig) {
+	hub := newHub(conf)
+	hub.run()
 	wait(hub)
 }
 
+// Inspect all docks known by the hub at addr. The hub running in this process
+// is inspected directly, otherwise through network, which is refused by the
+// hub with authentication unless PublicInspect is set.
+func Inspect(hubAddr string) ([]DockInfo, error) {
+	return inspect(hubAddr, "", "")
+}
+
+// InspectWith inspects like Inspect, but authenticates to the hub as the dock
+// named name with secret.
+func InspectWith(hubAddr string, name string, secret string) ([]DockInfo, error) {
+	return inspect(hubAddr, name, secret)
+}
+
 // Close all containers including hub or dock.
 func Close() {
 	destroy()
 }
 
 // Carry an ISlot object with kernel feature that should implement IFeature interface.
+// The slot is not discoverable means it's hidden from hub and other docks, and
+// only callable in the same dock.
 func Carry(id string, feature interface{}, discoverable bool) ISlot {
 	return newSlot(id, feature, discoverable)
 }
 
+// WaitAll futures complete, and return all return values in order with the
+// first error if there is any.
+func WaitAll(futures ...IFuture) ([][]interface{}, error) {
+	return waitAll(futures)
+}
+
+// WaitAny future completes, and return its index and return values.
+func WaitAny(futures ...IFuture) (int, []interface{}, error) {
+	return waitAny(futures)
+}
+
 // Register feature id with proxy maker func.
 func Register(id string, maker interface{}) bool {
 	register(id, maker)
@@ -5,12 +5,22 @@
 package ferry_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/muguangyi/ferry"
+	"github.com/muguangyi/ferry/codec"
 	"github.com/muguangyi/ferry/network"
 )
 
@@ -102,3 +112,1939 @@ func TestMultiDocks(t *testing.T) {
 
 	ferry.Close()
 }
+
+type seeker struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (s *seeker) OnStart(slot ferry.ISlot) {
+	_, err := slot.CallWithResult("IMissing", "Any")
+	if nil == err {
+		s.t.Error("Call to missing slot should fail!")
+	} else {
+		s.t.Log(err)
+	}
+	s.wg.Done()
+}
+
+func TestQueryTimeout(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", QueryTimeout: 0.1})
+
+	go ferry.Startup("127.0.0.1:55555", "seeker",
+		ferry.Carry("ISeeker", &seeker{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+func TestBalancers(t *testing.T) {
+	addrs := []ferry.Instance{
+		{Name: "a", Addr: "10.0.0.1:20001"},
+		{Name: "b", Addr: "10.0.0.2:20001"},
+		{Name: "c", Addr: "10.0.0.3:20001"},
+	}
+
+	rr := ferry.RoundRobin()
+	for i := 0; i < 6; i++ {
+		if index := rr.Pick("caller", addrs); index != i%len(addrs) {
+			t.Errorf("RoundRobin picked %d, expect %d", index, i%len(addrs))
+		}
+	}
+
+	weighted := ferry.Weighted(map[string]int{"a": 0, "b": 0})
+	for i := 0; i < 10; i++ {
+		if index := weighted.Pick("caller", addrs); 2 != index {
+			t.Errorf("Weighted picked %d with zero weight", index)
+		}
+	}
+
+	sticky := ferry.Sticky()
+	first := sticky.Pick("caller", addrs)
+	for i := 0; i < 10; i++ {
+		if index := sticky.Pick("caller", addrs); index != first {
+			t.Errorf("Sticky picked %d, expect %d", index, first)
+		}
+	}
+
+	random := ferry.Random()
+	for i := 0; i < 10; i++ {
+		if index := random.Pick("caller", addrs); index < 0 || index >= len(addrs) {
+			t.Errorf("Random picked %d out of range", index)
+		}
+	}
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", DisableAllocator: true})
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "logger", ListenAddr: "0.0.0.0:0"},
+		ferry.Carry("ILogger", &logger{wg: &wg}, true))
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "add", AdvertiseAddr: ":30001"},
+		ferry.Carry("IAdd", &add{wg: &wg}, true))
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "logic", ListenAddr: "0.0.0.0:0"},
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+// relay forwards packets between the peers connecting in and the server at
+// target, like the network in between which could hang or break.
+type relay struct {
+	target string
+	socket network.ISocket
+	mutex  sync.Mutex
+	pairs  map[network.IPeer]network.IPeer
+	frozen bool
+	down   bool
+}
+
+func newRelay(addr string, target string) *relay {
+	r := &relay{target: target, pairs: make(map[network.IPeer]network.IPeer)}
+	r.socket = network.NewSocket(addr, "ferry", r)
+	r.socket.Listen()
+
+	return r
+}
+
+func (r *relay) OnConnected(in network.IPeer) {
+	r.mutex.Lock()
+	down := r.down
+	r.mutex.Unlock()
+	if down {
+		go in.Close()
+		return
+	}
+
+	socket := network.NewSocket(r.target, "ferry", &relayEnd{relay: r, in: in})
+	if err := socket.Dial(); nil != err {
+		go in.Close()
+	}
+}
+
+func (r *relay) OnClosed(in network.IPeer) {
+	r.mutex.Lock()
+	out, ok := r.pairs[in]
+	delete(r.pairs, in)
+	r.mutex.Unlock()
+
+	if ok {
+		go out.Close()
+	}
+}
+
+func (r *relay) OnPacket(in network.IPeer, obj interface{}) {
+	r.mutex.Lock()
+	out, ok := r.pairs[in]
+	frozen := r.frozen
+	r.mutex.Unlock()
+
+	if ok && !frozen {
+		out.Send(obj)
+	}
+}
+
+// freeze drops all packets from now on, like both sides hang.
+func (r *relay) freeze() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.frozen = true
+}
+
+// cut breaks all connections and refuses new ones, like the server crashes.
+func (r *relay) cut() {
+	r.mutex.Lock()
+	r.down = true
+	pairs := r.pairs
+	r.pairs = make(map[network.IPeer]network.IPeer)
+	r.mutex.Unlock()
+
+	for in, out := range pairs {
+		in.Close()
+		out.Close()
+	}
+}
+
+func (r *relay) close() {
+	r.cut()
+	r.socket.Close()
+}
+
+// relayEnd is the connection from relay to the server.
+type relayEnd struct {
+	relay *relay
+	in    network.IPeer
+}
+
+func (e *relayEnd) OnConnected(out network.IPeer) {
+	e.relay.mutex.Lock()
+	defer e.relay.mutex.Unlock()
+
+	e.relay.pairs[e.in] = out
+}
+
+func (e *relayEnd) OnClosed(out network.IPeer) {
+	e.relay.mutex.Lock()
+	delete(e.relay.pairs, e.in)
+	e.relay.mutex.Unlock()
+
+	go e.in.Close()
+}
+
+func (e *relayEnd) OnPacket(out network.IPeer, obj interface{}) {
+	e.relay.mutex.Lock()
+	frozen := e.relay.frozen
+	e.relay.mutex.Unlock()
+
+	if !frozen {
+		e.in.Send(obj)
+	}
+}
+
+// waitHub inspects hub until it's up, which also prepares the serializer for
+// relay.
+func waitHub(t *testing.T, hubAddr string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := ferry.Inspect(hubAddr); nil == err {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("[%s] hub is not up!", hubAddr)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type asker struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (a *asker) OnStart(s ferry.ISlot) {
+	defer a.wg.Done()
+
+	if err := s.Call("ILogger", "Log", "Anyone?"); ferry.CodeNotFound != ferry.ErrorCode(err) {
+		a.t.Errorf("Call to evicted dock should fail with CodeNotFound: %v", err)
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", HeartbeatTimeout: 0.3, QueryTimeout: 0.2})
+	waitHub(t, "127.0.0.1:55555")
+
+	// The dock reaches hub through relay, which hangs later.
+	r := newRelay("127.0.0.1:55560", "127.0.0.1:55555")
+	defer r.close()
+
+	var wg sync.WaitGroup
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55560", Name: "hung", HeartbeatInterval: 0.05},
+		ferry.Carry("ILogger", &logger{wg: &wg}, true))
+
+	waitReady(t, "127.0.0.1:55555", 1)
+	r.freeze()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		docks, err := ferry.Inspect("127.0.0.1:55555")
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0 == len(docks) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dock without heartbeat is not evicted: %v", docks)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	wg.Add(1)
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "asker", HeartbeatInterval: 0.05},
+		ferry.Carry("IAsker", &asker{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type hanger struct {
+	ferry.Feature
+	started chan bool
+}
+
+func (h *hanger) Hang(ctx context.Context) {
+	h.started <- true
+	<-ctx.Done()
+}
+
+type hangee struct {
+	ferry.Feature
+	errs chan error
+}
+
+func (h *hangee) OnStart(s ferry.ISlot) {
+	h.errs <- s.Call("IHanger", "Hang")
+}
+
+func TestRemoteClosed(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.Serve("127.0.0.1:55555")
+	waitHub(t, "127.0.0.1:55555")
+
+	// Other docks reach the hanger dock through relay, which breaks later.
+	r := newRelay("127.0.0.1:30003", "127.0.0.1:30002")
+	defer r.close()
+
+	started := make(chan bool, 1)
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "hanger", ListenAddr: "127.0.0.1:30002", AdvertiseAddr: "127.0.0.1:30003"},
+		ferry.Carry("IHanger", &hanger{started: started}, true))
+
+	errs := make(chan error, 1)
+	go ferry.Startup("127.0.0.1:55555", "hangee",
+		ferry.Carry("IHangee", &hangee{errs: errs}, true))
+
+	<-started
+	r.cut()
+
+	select {
+	case err := <-errs:
+		if ferry.CodeUnavailable != ferry.ErrorCode(err) {
+			t.Errorf("Call to closed dock should fail with CodeUnavailable: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Call to closed dock hangs!")
+	}
+
+	ferry.Close()
+}
+
+func TestReplicatedHubs(t *testing.T) {
+	network.Mock("tcp")
+
+	// Hub A at 55565 is reached by docks and hub B only through relay at
+	// 55555, and it reaches hub B through relay at 55566, so it's down once
+	// both relays are cut.
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55565", Peers: []string{"127.0.0.1:55566"}})
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55556", Peers: []string{"127.0.0.1:55555"}})
+	waitHub(t, "127.0.0.1:55565")
+	waitHub(t, "127.0.0.1:55556")
+
+	ra := newRelay("127.0.0.1:55555", "127.0.0.1:55565")
+	defer ra.close()
+	rb := newRelay("127.0.0.1:55566", "127.0.0.1:55556")
+	defer rb.close()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.Startup("127.0.0.1:55555,127.0.0.1:55556", "util",
+		ferry.Carry("ILogger", &logger{wg: &wg}, true),
+		ferry.Carry("IAdd", &add{wg: &wg}, true))
+
+	go ferry.Startup("127.0.0.1:55556,127.0.0.1:55555", "logic",
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	// Both hubs allocate ports, but never the same one.
+	docks := waitReady(t, "127.0.0.1:55556", 3)
+	if 2 != len(docks) || docks[0].Addr == docks[1].Addr {
+		t.Fatalf("Unexpected docks: %v", docks)
+	}
+
+	// The util dock fails over to hub B when hub A is down.
+	ra.cut()
+	rb.cut()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		docks, err := ferry.Inspect("127.0.0.1:55556")
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		moved := false
+		for _, d := range docks {
+			if "util" == d.Name && "127.0.0.1:55556" == d.Hub {
+				moved = true
+			}
+		}
+		if moved {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dock doesn't fail over to hub B: %v", docks)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Slots are still found through hub B.
+	wg.Add(3)
+	go ferry.Startup("127.0.0.1:55556", "logic-2",
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+func TestInspect(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	go ferry.Startup("127.0.0.1:55555", "1",
+		ferry.Carry("ILogger", &logger{wg: &wg}, true),
+		ferry.Carry("IAdd", &add{wg: &wg}, true),
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	// Inspect in process, and through network with another addr.
+	for _, addr := range []string{"127.0.0.1:55555", "localhost:55555"} {
+		docks := waitReady(t, addr, 3)
+		if 1 != len(docks) || 3 != len(docks[0].Slots) {
+			t.Fatalf("Unexpected docks: %v", docks)
+		}
+	}
+
+	ferry.Close()
+}
+
+// waitReady inspects hub until the count of ready slots reaches n.
+func waitReady(t *testing.T, hubAddr string, n int) []ferry.DockInfo {
+	deadline := time.Now().Add(time.Second)
+	for {
+		docks, err := ferry.Inspect(hubAddr)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		ready := 0
+		for _, d := range docks {
+			for _, s := range d.Slots {
+				if s.Ready {
+					ready++
+				}
+			}
+		}
+		if ready >= n {
+			return docks
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d slots are ready: %v", ready, docks)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	network.Mock("tcp")
+
+	dir, err := ioutil.TempDir("", "ferry")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := ferry.HubConfig{Addr: "127.0.0.1:55555", Snapshot: filepath.Join(dir, "hub.snapshot")}
+
+	var wg sync.WaitGroup
+	dock := ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "logger", AdvertiseAddr: "127.0.0.1:30005"}
+
+	go ferry.ServeWith(conf)
+
+	go ferry.StartupWith(dock, ferry.Carry("ILogger", &logger{wg: &wg}, true))
+
+	waitReady(t, "127.0.0.1:55555", 1)
+	ferry.Close()
+
+	// Restart hub and the dock is restored but not ready.
+	network.Mock("tcp")
+	go ferry.ServeWith(conf)
+
+	var docks []ferry.DockInfo
+	for i := 0; i < 100 && 0 == len(docks); i++ {
+		time.Sleep(10 * time.Millisecond)
+		docks, _ = ferry.Inspect("127.0.0.1:55555")
+	}
+	if 1 != len(docks) || 1 != len(docks[0].Slots) || "ILogger" != docks[0].Slots[0].ID {
+		t.Fatalf("Unexpected restored docks: %v", docks)
+	}
+	if docks[0].Slots[0].Ready {
+		t.Error("Restored slot should not be ready!")
+	}
+
+	// The dock comes back with the same addr and reclaims the restored one.
+	go ferry.StartupWith(dock, ferry.Carry("ILogger", &logger{wg: &wg}, true))
+
+	docks = waitReady(t, "127.0.0.1:55555", 1)
+	if 1 != len(docks) || "127.0.0.1:30005" != docks[0].Addr {
+		t.Fatalf("Unexpected reclaimed docks: %v", docks)
+	}
+
+	ferry.Close()
+
+	// Bad records in snapshot are skipped.
+	file, err := os.Create(conf.Snapshot)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = codec.NewAny([]interface{}{
+		[]interface{}{"127.0.0.1:30006"},
+		[]interface{}{"127.0.0.1:30007", "127.0.0.1", 30007, []interface{}{1}, time.Now().UnixNano()},
+	}).Encode(file)
+	file.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	network.Mock("tcp")
+	go ferry.ServeWith(conf)
+	waitHub(t, "127.0.0.1:55555")
+
+	if docks, err = ferry.Inspect("127.0.0.1:55555"); nil != err || 0 != len(docks) {
+		t.Errorf("Unexpected docks from bad snapshot: %v %v", docks, err)
+	}
+
+	ferry.Close()
+}
+
+type doubter struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (d *doubter) OnStart(s ferry.ISlot) {
+	defer d.wg.Done()
+
+	if _, err := s.CallWithResult("IVault", "Peek"); nil == err {
+		d.t.Error("Call to dock failing authentication should fail!")
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	tokens := map[string]string{"logic": "token", "doubter": "doubt"}
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", Secret: "secret", Tokens: tokens})
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "util", Secret: "secret", Tokens: tokens},
+		ferry.Carry("ILogger", &logger{wg: &wg}, true),
+		ferry.Carry("IAdd", &add{wg: &wg}, true),
+		ferry.Carry("IVault", &vault{}, true))
+
+	// The dialed util is checked with its secret, and doubter doesn't know
+	// it.
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "logic", Secret: "token",
+		Tokens: map[string]string{"util": "secret"}},
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "doubter", Secret: "doubt", CallTimeout: 0.5},
+		ferry.Carry("IDoubter", &doubter{t: t, wg: &wg}, true))
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "intruder", Secret: "guess"},
+		ferry.Carry("ISeeker", &seeker{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	docks := waitReady(t, "127.0.0.1:55555", 4)
+	for _, d := range docks {
+		for _, s := range d.Slots {
+			if "ISeeker" == s.ID {
+				t.Error("Dock with wrong secret should not be registered!")
+			}
+		}
+	}
+
+	// Inspecting through network needs authentication too.
+	if docks, err := ferry.Inspect("localhost:55555"); nil == err {
+		t.Errorf("Inspect without authentication should fail: %v", docks)
+	}
+	if docks, err := ferry.InspectWith("localhost:55555", "logic", "guess"); nil == err {
+		t.Errorf("Inspect with wrong secret should fail: %v", docks)
+	}
+	if docks, err := ferry.InspectWith("localhost:55555", "logic", "token"); nil != err || 3 != len(docks) {
+		t.Errorf("Inspect with token returns %v with error: %v", docks, err)
+	}
+
+	ferry.Close()
+}
+
+type watcher struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (w *watcher) OnStart(s ferry.ISlot) {
+	events := s.Watch("IAdd")
+	go func() {
+		defer w.wg.Done()
+		for _, kind := range []ferry.SlotEventKind{ferry.SlotAdded, ferry.SlotReady} {
+			select {
+			case e := <-events:
+				if kind != e.Kind || "IAdd" != e.ID {
+					w.t.Errorf("Unexpected slot event: %v", e)
+				}
+			case <-time.After(time.Second):
+				w.t.Errorf("Slot event %d timeout!", kind)
+				return
+			}
+		}
+	}()
+}
+
+func TestWatch(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	go ferry.Startup("127.0.0.1:55555", "watcher",
+		ferry.Carry("IWatcher", &watcher{t: t, wg: &wg}, true))
+
+	waitReady(t, "127.0.0.1:55555", 1)
+
+	go ferry.Startup("127.0.0.1:55555", "add",
+		ferry.Carry("IAdd", &add{wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+func TestWatchCoalesce(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	watchers := make(chan ferry.ISlot, 1)
+	flippers := make(chan ferry.ISlot, 1)
+	go ferry.Startup("127.0.0.1:55555", "watcher", ferry.Carry("IWatcher", &holder{slots: watchers}, true))
+	go ferry.Startup("127.0.0.1:55555", "flipper", ferry.Carry("IFlipper", &holder{slots: flippers}, true))
+	w := <-watchers
+	f := <-flippers
+
+	events := w.Watch("IFlip")
+	time.Sleep(50 * time.Millisecond)
+
+	// The watcher doesn't keep up with the instance flipping.
+	for i := 0; i < 50; i++ {
+		if err := f.Carry(ferry.Carry("IFlip", &room{name: "flip"}, true)); nil != err {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if err := f.Uncarry("IFlip"); nil != err {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	var last ferry.SlotEvent
+	n := 0
+	for quiet := false; !quiet; {
+		select {
+		case last = <-events:
+			n++
+		case <-time.After(100 * time.Millisecond):
+			quiet = true
+		}
+	}
+	if 0 == n || ferry.SlotRemoved != last.Kind {
+		t.Errorf("Last event of %d is %v, expect removed", n, last)
+	}
+
+	w.Unwatch(events)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Channel should be closed after unwatched!")
+		}
+	case <-time.After(time.Second):
+		t.Error("Channel should be closed after unwatched!")
+	}
+
+	ferry.Close()
+}
+
+func TestWatchFailover(t *testing.T) {
+	network.Mock("tcp")
+
+	// The watcher reaches hub A only through relay, and fails over to hub B
+	// which doesn't know the instance on hub A.
+	go ferry.Serve("127.0.0.1:55565")
+	go ferry.Serve("127.0.0.1:55556")
+	waitHub(t, "127.0.0.1:55565")
+	waitHub(t, "127.0.0.1:55556")
+
+	ra := newRelay("127.0.0.1:55555", "127.0.0.1:55565")
+	defer ra.close()
+
+	go ferry.Startup("127.0.0.1:55565", "flip", ferry.Carry("IFlip", &room{name: "flip"}, true))
+
+	watchers := make(chan ferry.ISlot, 1)
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555,127.0.0.1:55556", Name: "watcher", ReconnectInterval: 0.05},
+		ferry.Carry("IWatcher", &holder{slots: watchers}, true))
+	events := (<-watchers).Watch("IFlip")
+
+	next := func() ferry.SlotEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(3 * time.Second):
+			t.Fatal("Slot event timeout!")
+			return ferry.SlotEvent{}
+		}
+	}
+	for _, kind := range []ferry.SlotEventKind{ferry.SlotAdded, ferry.SlotReady} {
+		if e := next(); kind != e.Kind {
+			t.Fatalf("Unexpected slot event: %v", e)
+		}
+	}
+
+	ra.cut()
+	if e := next(); ferry.SlotRemoved != e.Kind || "IFlip" != e.ID {
+		t.Errorf("Instance gone after failover should be removed: %v", e)
+	}
+
+	ferry.Close()
+}
+
+func TestReconnect(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "util", ReconnectInterval: 0.05},
+		ferry.Carry("ILogger", &logger{wg: &wg}, true),
+		ferry.Carry("IAdd", &add{wg: &wg}, true))
+
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "logic", ReconnectInterval: 0.05},
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	// Hub is up after docks.
+	time.Sleep(200 * time.Millisecond)
+	go ferry.Serve("127.0.0.1:55555")
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type shift struct {
+	ferry.Feature
+	t        *testing.T
+	name     string
+	started  chan bool
+	release  chan bool
+	done     chan bool
+	working  bool
+	finished bool
+	watches  chan (<-chan ferry.SlotEvent)
+}
+
+func (s *shift) OnStart(slot ferry.ISlot) {
+	if nil != s.watches {
+		s.watches <- slot.Watch("IShift")
+	}
+}
+
+func (s *shift) Work() string {
+	s.working = true
+	s.started <- true
+	<-s.release
+	s.finished = true
+	return s.name
+}
+
+func (s *shift) Name() string {
+	return s.name
+}
+
+func (s *shift) OnDestroy(slot ferry.ISlot) {
+	if s.working && !s.finished {
+		s.t.Error("Feature is destroyed before the call finished!")
+	}
+
+	// Keep the links until the result is received by caller.
+	if nil != s.done {
+		<-s.done
+	}
+}
+
+// holder hands its slot to test for calling from outside.
+type holder struct {
+	ferry.Feature
+	slots chan ferry.ISlot
+}
+
+func (h *holder) OnStart(s ferry.ISlot) {
+	h.slots <- s
+}
+
+func TestDrain(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.Serve("127.0.0.1:55555")
+	waitHub(t, "127.0.0.1:55555")
+
+	slots := make(chan ferry.ISlot, 1)
+	go ferry.Startup("127.0.0.1:55555", "boss",
+		ferry.Carry("IBoss", &holder{slots: slots}, true))
+	boss := <-slots
+
+	go ferry.Startup("127.0.0.1:55555", "shift-b",
+		ferry.Carry("IHolder", &holder{slots: slots}, true))
+	b := <-slots
+
+	// The shift-a dock is closed first as it starts last.
+	a := &shift{t: t, name: "a", started: make(chan bool, 1), release: make(chan bool), done: make(chan bool)}
+	go ferry.Startup("127.0.0.1:55555", "shift-a",
+		ferry.Carry("IShift", a, true))
+	waitReady(t, "127.0.0.1:55555", 3)
+
+	// The only instance is on shift-a, and boss keeps the link to it.
+	if r, err := boss.CallWithResult("IShift", "Name"); nil != err || "a" != r[0].(string) {
+		t.Fatalf("Name returns %v with error [%v], expect [a]", r, err)
+	}
+
+	if err := b.Carry(ferry.Carry("IShift", &shift{t: t, name: "b"}, true)); nil != err {
+		t.Fatal(err)
+	}
+	waitReady(t, "127.0.0.1:55555", 4)
+
+	works := make(chan error, 1)
+	go func() {
+		r, err := boss.CallWithResult("IShift@shift-a", "Work")
+		if nil == err && "a" != r[0].(string) {
+			err = fmt.Errorf("Work returns %v, expect [a]", r)
+		}
+		works <- err
+	}()
+	<-a.started
+
+	closed := make(chan bool)
+	go func() {
+		ferry.Close()
+		close(closed)
+	}()
+
+	// Wait until hub knows shift-a is draining.
+	deadline := time.Now().Add(time.Second)
+	for draining := false; !draining; {
+		docks, err := ferry.Inspect("127.0.0.1:55555")
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, d := range docks {
+			if "shift-a" == d.Name && !d.Slots[0].Ready {
+				draining = true
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dock is not draining: %v", docks)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The draining shift-a refuses the new call through the link, and boss
+	// retries it on shift-b.
+	if r, err := boss.CallWithResult("IShift", "Name"); nil != err || "b" != r[0].(string) {
+		t.Errorf("Name returns %v with error [%v], expect [b]", r, err)
+	}
+
+	// The call in progress is finished before shift-a is closed.
+	close(a.release)
+	if err := <-works; nil != err {
+		t.Error(err)
+	}
+	close(a.done)
+
+	<-closed
+}
+
+func TestUncarryDrain(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.Serve("127.0.0.1:55555")
+	waitHub(t, "127.0.0.1:55555")
+
+	slots := make(chan ferry.ISlot, 1)
+	go ferry.Startup("127.0.0.1:55555", "boss",
+		ferry.Carry("IBoss", &holder{slots: slots}, true))
+	boss := <-slots
+	go ferry.Startup("127.0.0.1:55555", "shift-a",
+		ferry.Carry("IHolder", &holder{slots: slots}, true))
+	ha := <-slots
+	go ferry.Startup("127.0.0.1:55555", "shift-b",
+		ferry.Carry("IHolder", &holder{slots: slots}, true))
+	hb := <-slots
+
+	a := &shift{t: t, name: "a", started: make(chan bool, 1), release: make(chan bool), watches: make(chan (<-chan ferry.SlotEvent), 1)}
+	if err := ha.Carry(ferry.Carry("IShift", a, true)); nil != err {
+		t.Fatal(err)
+	}
+	events := <-a.watches
+	if err := hb.Carry(ferry.Carry("IShift", &shift{t: t, name: "b"}, true)); nil != err {
+		t.Fatal(err)
+	}
+	waitReady(t, "127.0.0.1:55555", 5)
+
+	// Boss keeps the link to shift-a.
+	if r, err := boss.CallWithResult("IShift@shift-a", "Name"); nil != err || "a" != r[0].(string) {
+		t.Fatalf("Name returns %v with error [%v], expect [a]", r, err)
+	}
+
+	works := make(chan error, 1)
+	go func() {
+		r, err := boss.CallWithResult("IShift@shift-a", "Work")
+		if nil == err && "a" != r[0].(string) {
+			err = fmt.Errorf("Work returns %v, expect [a]", r)
+		}
+		works <- err
+	}()
+	<-a.started
+
+	uncarried := make(chan error, 1)
+	go func() {
+		uncarried <- ha.Uncarry("IShift")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The uncarrying slot refuses new calls, and boss retries them on
+	// shift-b.
+	for i := 0; i < 5; i++ {
+		if r, err := boss.CallWithResult("IShift", "Name"); nil != err || "b" != r[0].(string) {
+			t.Errorf("Name returns %v with error [%v], expect [b]", r, err)
+		}
+	}
+
+	select {
+	case <-uncarried:
+		t.Error("Slot is uncarried before the call in progress finished!")
+	default:
+	}
+
+	close(a.release)
+	if err := <-works; nil != err {
+		t.Error(err)
+	}
+	if err := <-uncarried; nil != err {
+		t.Error(err)
+	}
+
+	// The watches of the uncarried slot are closed.
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-events:
+			closed = !ok
+		case <-time.After(time.Second):
+			t.Fatal("Watch of uncarried slot is not closed!")
+		}
+	}
+
+	ferry.Close()
+}
+
+func TestCallTimeout(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	start := time.Now()
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "seeker", CallTimeout: 0.1},
+		ferry.Carry("ISeeker", &seeker{t: t, wg: &wg}, true))
+
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Call timeout after %v", elapsed)
+	}
+
+	ferry.Close()
+}
+
+type IPing interface {
+	Ping(n int) int
+}
+
+type ping struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (p *ping) Ping(n int) int {
+	return n + 1
+}
+
+func (p *ping) OnStart(s ferry.ISlot) {
+	for i := 0; i < 3; i++ {
+		result, err := s.CallWithResult("IPong", "Pong", i)
+		if nil != err {
+			p.t.Error(err)
+		} else if i+2 != toInt(result[0]) {
+			p.t.Errorf("Pong result is %v, expect %d", result[0], i+2)
+		}
+	}
+	p.wg.Done()
+}
+
+type IPong interface {
+	Pong(n int) int
+}
+
+type pong struct {
+	ferry.Feature
+	slot    ferry.ISlot
+	started chan bool
+}
+
+func (p *pong) OnStart(s ferry.ISlot) {
+	p.slot = s
+	close(p.started)
+}
+
+// Pong calls back the dock which calls it, and it may be called before
+// OnStart.
+func (p *pong) Pong(n int) int {
+	<-p.started
+	result, err := p.slot.CallWithResult("IPing", "Ping", n)
+	if nil != err {
+		return -1
+	}
+
+	return toInt(result[0]) + 1
+}
+
+// toInt converts the integer decoded in any size.
+func toInt(v interface{}) int {
+	return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int())
+}
+
+func TestSharedLink(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	go ferry.Startup("127.0.0.1:55555", "pong",
+		ferry.Carry("IPong", &pong{started: make(chan bool)}, true))
+
+	go ferry.Startup("127.0.0.1:55555", "ping",
+		ferry.Carry("IPing", &ping{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type spawner struct {
+	ferry.Feature
+	t    *testing.T
+	wg   *sync.WaitGroup
+	slot ferry.ISlot
+}
+
+func (s *spawner) OnStart(slot ferry.ISlot) {
+	s.slot = slot
+	if err := slot.Carry(ferry.Carry("IAdd", &add{wg: s.wg}, true)); nil != err {
+		s.t.Error(err)
+	}
+}
+
+func TestCarryAtRuntime(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	sp := &spawner{t: t, wg: &wg}
+	go ferry.Startup("127.0.0.1:55555", "spawner",
+		ferry.Carry("ILogger", &logger{wg: &wg}, true),
+		ferry.Carry("ISpawner", sp, true))
+
+	go ferry.Startup("127.0.0.1:55555", "logic",
+		ferry.Carry("ILogic", &logic{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	if err := sp.slot.Uncarry("IAdd"); nil != err {
+		t.Fatal(err)
+	}
+	if err := sp.slot.Uncarry("IAdd"); nil == err {
+		t.Error("Uncarry missing slot should fail!")
+	}
+
+	for i := 0; ; i++ {
+		docks, err := ferry.Inspect("127.0.0.1:55555")
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		found := false
+		for _, d := range docks {
+			for _, s := range d.Slots {
+				found = found || "IAdd" == s.ID
+			}
+		}
+		if !found {
+			break
+		}
+		if i >= 100 {
+			t.Fatal("Uncarried slot is still registered!")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ferry.Close()
+}
+
+type IRoom interface {
+	Name() string
+}
+
+type room struct {
+	ferry.Feature
+	name string
+}
+
+func (r *room) Name() string {
+	return r.name
+}
+
+type lobby struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (l *lobby) OnStart(s ferry.ISlot) {
+	defer l.wg.Done()
+
+	for i := 0; i < 4; i++ {
+		target := fmt.Sprintf("room-%d", 1+i%2)
+		result, err := s.CallWithResult("IRoom@"+target, "Name")
+		if nil != err {
+			l.t.Error(err)
+		} else if target != result[0].(string) {
+			l.t.Errorf("Called room [%v], expect [%s]", result[0], target)
+		}
+	}
+
+	if _, err := s.CallWithResult("IRoom@room-9", "Name"); nil == err {
+		l.t.Error("Call to missing dock should fail!")
+	}
+}
+
+func TestTargetedCall(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", QueryTimeout: 0.2})
+
+	for _, name := range []string{"room-1", "room-2"} {
+		go ferry.Startup("127.0.0.1:55555", name,
+			ferry.Carry("IRoom", &room{name: name}, true))
+	}
+
+	waitReady(t, "127.0.0.1:55555", 2)
+
+	go ferry.Startup("127.0.0.1:55555", "lobby",
+		ferry.Carry("ILobby", &lobby{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	docks := waitReady(t, "127.0.0.1:55555", 3)
+	for _, d := range docks {
+		if "" == d.Name || "" == d.ID {
+			t.Errorf("Dock name or id is missing: %v", d)
+		}
+	}
+
+	ferry.Close()
+}
+
+func TestBalancedCalls(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.ServeWith(ferry.HubConfig{
+		Addr:      "127.0.0.1:55555",
+		Balancers: map[string]ferry.IBalancer{"IGate": ferry.Weighted(map[string]int{"m1": 0})},
+	})
+
+	for _, name := range []string{"m1", "m2"} {
+		go ferry.Startup("127.0.0.1:55555", name,
+			ferry.Carry("IHall", &room{name: name}, true),
+			ferry.Carry("IGate", &room{name: name}, true))
+	}
+
+	waitReady(t, "127.0.0.1:55555", 4)
+
+	slots := make(chan ferry.ISlot, 1)
+	go ferry.Startup("127.0.0.1:55555", "caller", ferry.Carry("ICaller", &holder{slots: slots}, false))
+	s := <-slots
+
+	for _, slot := range []string{"IHall", "IGate"} {
+		counts := make(map[string]int)
+		for i := 0; i < 20; i++ {
+			result, err := s.CallWithResult(slot, "Name")
+			if nil != err {
+				t.Fatal(err)
+			}
+			counts[result[0].(string)]++
+		}
+
+		if "IHall" == slot && (10 != counts["m1"] || 10 != counts["m2"]) {
+			t.Errorf("Calls to [%s] are spread as %v, expect in turn", slot, counts)
+		} else if "IGate" == slot && 20 != counts["m2"] {
+			t.Errorf("Calls to [%s] are spread as %v, expect all to m2", slot, counts)
+		}
+	}
+
+	ferry.Close()
+}
+
+type vault struct {
+	ferry.Feature
+}
+
+func (v *vault) Open() string {
+	return "opened"
+}
+
+func (v *vault) Peek() string {
+	return "peeked"
+}
+
+type visitor struct {
+	ferry.Feature
+	t       *testing.T
+	wg      *sync.WaitGroup
+	known   bool
+	trusted bool
+}
+
+func (v *visitor) OnStart(s ferry.ISlot) {
+	defer v.wg.Done()
+
+	_, err := s.CallWithResult("IVault", "Peek")
+	if v.known && nil != err {
+		v.t.Error(err)
+	} else if !v.known && ferry.CodeDenied != ferry.ErrorCode(err) {
+		v.t.Errorf("Call from unknown slot should be denied: %v", err)
+	}
+
+	_, err = s.CallWithResult("IVault", "Open")
+	if v.trusted && nil != err {
+		v.t.Error(err)
+	} else if !v.trusted && ferry.CodeDenied != ferry.ErrorCode(err) {
+		v.t.Errorf("Call from untrusted dock should be denied: %v", err)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", Secret: "secret"})
+
+	// The docks are named by authentication, and only IVisitor is allowed on
+	// the stranger dock.
+	slot := ferry.Carry("IVault", &vault{}, true)
+	slot.Allow("Open", "trusted")
+	slot.Allow("*", "trusted", "IVisitor@stranger")
+	slots := make(chan ferry.ISlot, 1)
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "vault", Secret: "secret"},
+		slot, ferry.Carry("IKeeper", &holder{slots: slots}, false))
+	keeper := <-slots
+
+	desk := ferry.Carry("IDesk", &vault{}, true)
+	desk.Allow("*", "vault")
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "trusted", Secret: "secret"},
+		desk, ferry.Carry("IVisitor", &visitor{t: t, wg: &wg, known: true, trusted: true}, true))
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "stranger", Secret: "secret"},
+		ferry.Carry("IVisitor", &visitor{t: t, wg: &wg, known: true, trusted: false}, true),
+		ferry.Carry("IGuest", &visitor{t: t, wg: &wg, known: false, trusted: false}, true))
+
+	wg.Wait()
+
+	// The dialed vault is authenticated too when calling back through the
+	// link of trusted.
+	if _, err := keeper.CallWithResult("IDesk", "Open"); nil != err {
+		t.Error(err)
+	}
+
+	ferry.Close()
+}
+
+type prober struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (p *prober) OnStart(s ferry.ISlot) {
+	defer p.wg.Done()
+
+	if _, err := s.CallWithResult("IRoom", "Missing"); ferry.CodeNoMethod != ferry.ErrorCode(err) {
+		p.t.Errorf("Call to missing method should fail with CodeNoMethod: %v", err)
+	}
+	if _, err := s.CallWithResult("IRoom", "Name", 1); ferry.CodeBadArgs != ferry.ErrorCode(err) {
+		p.t.Errorf("Call with wrong args should fail with CodeBadArgs: %v", err)
+	}
+	if _, err := s.CallWithResult("IRoom", "Name"); nil != err {
+		p.t.Error(err)
+	}
+}
+
+func TestBadCall(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+	go ferry.Startup("127.0.0.1:55555", "room", ferry.Carry("IRoom", &room{name: "room"}, true))
+	go ferry.Startup("127.0.0.1:55555", "prober", ferry.Carry("IProber", &prober{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type dozer struct {
+	ferry.Feature
+	name string
+}
+
+func (d *dozer) Doze(ms int) string {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return d.name
+}
+
+type fanner struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (f *fanner) OnStart(s ferry.ISlot) {
+	defer f.wg.Done()
+
+	start := time.Now()
+	futures := make([]ferry.IFuture, 0)
+	for i := 1; i <= 3; i++ {
+		futures = append(futures, s.Go(fmt.Sprintf("IDozer@dozer-%d", i), "Doze", 200))
+	}
+	futures = append(futures, s.Go("IRoom", "Name"))
+
+	results, err := ferry.WaitAll(futures...)
+	if nil != err {
+		f.t.Error(err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		f.t.Errorf("Async calls take %v, expect running at once", elapsed)
+	}
+	for i := 1; i <= 3; i++ {
+		if name := fmt.Sprintf("dozer-%d", i); name != results[i-1][0].(string) {
+			f.t.Errorf("Result [%v], expect [%s]", results[i-1][0], name)
+		}
+	}
+	if "local" != results[3][0].(string) {
+		f.t.Errorf("Local result [%v], expect [local]", results[3][0])
+	}
+
+	if _, err := s.Go("IDozer@dozer-1", "Doze", 300).WaitTimeout(0.05); ferry.CodeTimeout != ferry.ErrorCode(err) {
+		f.t.Errorf("Wait should timeout: %v", err)
+	}
+
+	i, _, err := ferry.WaitAny(s.Go("IDozer@dozer-2", "Doze", 300), s.Go("IDozer@dozer-3", "Doze", 10))
+	if nil != err || 1 != i {
+		f.t.Errorf("WaitAny returns [%d] with error [%v], expect [1]", i, err)
+	}
+}
+
+func TestFuture(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("dozer-%d", i)
+		go ferry.Startup("127.0.0.1:55555", name, ferry.Carry("IDozer", &dozer{name: name}, true))
+	}
+
+	waitReady(t, "127.0.0.1:55555", 3)
+
+	go ferry.Startup("127.0.0.1:55555", "fanner",
+		ferry.Carry("IFanner", &fanner{t: t, wg: &wg}, true),
+		ferry.Carry("IRoom", &room{name: "local"}, false))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type notifier struct {
+	ferry.Feature
+	t *testing.T
+}
+
+func (n *notifier) OnStart(s ferry.ISlot) {
+	start := time.Now()
+	s.Notify("ILogger", "Log", "Remote notify")
+	s.Notify("IAdd", "Add", 1, 2)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		n.t.Errorf("Notify blocks for %v", elapsed)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	go ferry.Startup("127.0.0.1:55555", "logger",
+		ferry.Carry("ILogger", &logger{wg: &wg}, true))
+
+	go ferry.Startup("127.0.0.1:55555", "notifier",
+		ferry.Carry("INotifier", &notifier{t: t}, true),
+		ferry.Carry("IAdd", &add{wg: &wg}, false))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type worker struct {
+	ferry.Feature
+	stopped chan error
+}
+
+func (w *worker) Work(ctx context.Context, ms int) bool {
+	select {
+	case <-ctx.Done():
+		w.stopped <- ctx.Err()
+		return false
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return true
+	}
+}
+
+type boss struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (b *boss) OnStart(s ferry.ISlot) {
+	defer b.wg.Done()
+
+	if result, err := s.CallWithResultContext(context.Background(), "IWorker", "Work", 10); nil != err || !result[0].(bool) {
+		b.t.Errorf("Work returns %v with error [%v]", result, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := s.CallContext(ctx, "IWorker", "Work", 2000); context.Canceled != err {
+		b.t.Errorf("Call returns [%v], expect cancelled", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.CallContext(ctx, "IWorker", "Work", 2000); context.DeadlineExceeded != err {
+		b.t.Errorf("Call returns [%v], expect deadline exceeded", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	stopped := make(chan error, 2)
+
+	go ferry.Serve("127.0.0.1:55555")
+	go ferry.Startup("127.0.0.1:55555", "worker", ferry.Carry("IWorker", &worker{stopped: stopped}, true))
+	go ferry.Startup("127.0.0.1:55555", "boss", ferry.Carry("IBoss", &boss{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	// Remote method is stopped by the cancel and the deadline.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("Remote method is not cancelled!")
+		}
+	}
+
+	ferry.Close()
+}
+
+type board struct {
+	ferry.Feature
+}
+
+func (b *board) Pages(stream ferry.IStream, n int) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *board) Double(ctx context.Context, stream ferry.IStream) error {
+	for {
+		v, err := stream.Recv()
+		if io.EOF == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+		stream.Send(2 * toInt(v))
+	}
+}
+
+func (b *board) Title() string {
+	return "board"
+}
+
+func (b *board) Fail(stream ferry.IStream) error {
+	return errors.New("board is broken")
+}
+
+type reader struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (r *reader) OnStart(s ferry.ISlot) {
+	defer r.wg.Done()
+
+	for _, target := range []string{"IBoard@board", "ILocalBoard"} {
+		stream, err := s.Stream(context.Background(), target, "Pages", 40)
+		if nil != err {
+			r.t.Error(err)
+			return
+		}
+		for i := 0; ; i++ {
+			v, err := stream.Recv()
+			if io.EOF == err {
+				if 40 != i {
+					r.t.Errorf("Received %d pages from [%s], expect 40", i, target)
+				}
+				break
+			} else if nil != err {
+				r.t.Error(err)
+				return
+			} else if i != toInt(v) {
+				r.t.Errorf("Received page %v from [%s], expect %d", v, target, i)
+			}
+		}
+
+		stream, err = s.Stream(context.Background(), target, "Double")
+		if nil != err {
+			r.t.Error(err)
+			return
+		}
+		for i := 1; i <= 3; i++ {
+			stream.Send(i)
+		}
+
+		// Other calls of the slot go on while the stream is open.
+		if title, err := s.CallWithResult(target, "Title"); nil != err || "board" != title[0].(string) {
+			r.t.Errorf("Title returns %v with error [%v] from [%s] during stream", title, err, target)
+		}
+		stream.CloseSend()
+		for i := 1; i <= 3; i++ {
+			if v, err := stream.Recv(); nil != err || 2*i != toInt(v) {
+				r.t.Errorf("Received %v with error [%v] from [%s], expect %d", v, err, target, 2*i)
+			}
+		}
+		if _, err := stream.Recv(); io.EOF != err {
+			r.t.Errorf("Received error [%v] from [%s], expect EOF", err, target)
+		}
+
+		stream, err = s.Stream(context.Background(), target, "Fail")
+		if nil != err {
+			r.t.Error(err)
+			return
+		}
+		if _, err := stream.Recv(); nil == err || io.EOF == err {
+			r.t.Errorf("Received error [%v] from [%s], expect failure", err, target)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+	go ferry.Startup("127.0.0.1:55555", "board", ferry.Carry("IBoard", &board{}, true))
+	go ferry.Startup("127.0.0.1:55555", "reader",
+		ferry.Carry("IReader", &reader{t: t, wg: &wg}, true),
+		ferry.Carry("ILocalBoard", &board{}, false))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type cache struct {
+	ferry.Feature
+	name string
+}
+
+func (c *cache) Invalidate(key string) string {
+	return c.name
+}
+
+type reloader struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (r *reloader) OnStart(s ferry.ISlot) {
+	defer r.wg.Done()
+
+	ctx := context.Background()
+	replies, err := s.Broadcast(ctx, "ICache", "Invalidate", ferry.GatherAll, "key")
+	if nil == err || 3 != len(replies) {
+		r.t.Errorf("Broadcast to all gets %d replies with error [%v], expect 3 with error", len(replies), err)
+	}
+	for _, reply := range replies {
+		if nil == reply.Err && reply.Name != reply.Result[0].(string) {
+			r.t.Errorf("Reply from [%s] is %v", reply.Name, reply.Result)
+		} else if nil != reply.Err && "cache-3" != reply.Name {
+			r.t.Errorf("Reply from [%s] fails: %v", reply.Name, reply.Err)
+		}
+	}
+
+	if replies, err := s.Broadcast(ctx, "ICache", "Invalidate", ferry.GatherQuorum, "key"); nil != err {
+		r.t.Errorf("Broadcast to quorum fails with %d replies: %v", len(replies), err)
+	}
+
+	if replies, err := s.Broadcast(ctx, "ICache", "Invalidate", ferry.Gather(1), "key"); nil != err || 0 == len(replies) {
+		r.t.Errorf("Broadcast to first one gets %d replies: %v", len(replies), err)
+	}
+
+	if replies, err := s.Broadcast(ctx, "IMissing", "Any", ferry.GatherAll); nil != err || 0 != len(replies) {
+		r.t.Errorf("Broadcast to no instance gets %d replies: %v", len(replies), err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("cache-%d", i)
+		slot := ferry.Carry("ICache", &cache{name: name}, true)
+		if 3 == i {
+			slot.Allow("*", "nobody")
+		}
+		go ferry.Startup("127.0.0.1:55555", name, slot)
+	}
+
+	waitReady(t, "127.0.0.1:55555", 3)
+
+	go ferry.Startup("127.0.0.1:55555", "reloader", ferry.Carry("IReloader", &reloader{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
+
+type listener struct {
+	ferry.Feature
+	t        *testing.T
+	ready    *sync.WaitGroup
+	received chan int
+}
+
+func (l *listener) OnStart(s ferry.ISlot) {
+	if err := s.Subscribe("news", "OnNews"); nil != err {
+		l.t.Error(err)
+	}
+	l.ready.Done()
+}
+
+func (l *listener) OnNews(msg int) {
+	l.received <- msg
+}
+
+type publisher struct {
+	ferry.Feature
+	t     *testing.T
+	ready *sync.WaitGroup
+	count int
+}
+
+func (p *publisher) OnStart(s ferry.ISlot) {
+	// Wait for the subscriptions to reach hubs, and the other features in
+	// this dock start after OnStart returns.
+	go func() {
+		p.ready.Wait()
+		time.Sleep(50 * time.Millisecond)
+
+		for i := 0; i < p.count; i++ {
+			if err := s.Publish("news", i); nil != err {
+				p.t.Error(err)
+			}
+		}
+	}()
+}
+
+func TestPubSub(t *testing.T) {
+	network.Mock("tcp")
+
+	var ready sync.WaitGroup
+	ready.Add(3)
+
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55555", Peers: []string{"127.0.0.1:55556"}})
+	go ferry.ServeWith(ferry.HubConfig{Addr: "127.0.0.1:55556", Peers: []string{"127.0.0.1:55555"}})
+
+	listeners := make([]*listener, 3)
+	for i := range listeners {
+		listeners[i] = &listener{t: t, ready: &ready, received: make(chan int, 10)}
+	}
+	go ferry.Startup("127.0.0.1:55555", "listener-1", ferry.Carry("IListener", listeners[0], true))
+	go ferry.Startup("127.0.0.1:55556", "listener-2", ferry.Carry("IListener", listeners[1], true))
+
+	// Hubs are linked once the docks are synced.
+	waitReady(t, "127.0.0.1:55556", 2)
+
+	// The publisher dock has a subscriber too.
+	go ferry.Startup("127.0.0.1:55555", "publisher",
+		ferry.Carry("IListener", listeners[2], false),
+		ferry.Carry("IPublisher", &publisher{t: t, ready: &ready, count: 300}, true))
+
+	// The burst is more than the buffers, so publisher waits for the slow
+	// listeners instead of dropping messages.
+	for n := 0; n < 300; n++ {
+		for i, l := range listeners {
+			select {
+			case msg := <-l.received:
+				if n != msg {
+					t.Errorf("Listener %d received [%d], expect [%d]", i, msg, n)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Listener %d received %d messages, expect 300", i, n)
+			}
+		}
+	}
+
+	ferry.Close()
+}
+
+type laggard struct {
+	ferry.Feature
+	t        *testing.T
+	release  chan bool
+	received chan int
+}
+
+func (l *laggard) OnStart(s ferry.ISlot) {
+	if err := s.Subscribe("news", "OnNews"); nil != err {
+		l.t.Error(err)
+	}
+}
+
+func (l *laggard) OnNews(msg int) {
+	<-l.release
+	l.received <- msg
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	network.Mock("tcp")
+
+	go ferry.Serve("127.0.0.1:55555")
+
+	slots := make(chan ferry.ISlot, 1)
+	l := &laggard{t: t, release: make(chan bool), received: make(chan int, 1000)}
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "laggard", CallTimeout: 1},
+		ferry.Carry("ILaggard", l, true),
+		ferry.Carry("IHolder", &holder{slots: slots}, true))
+	sub := <-slots
+	go ferry.StartupWith(ferry.DockConfig{HubAddr: "127.0.0.1:55555", Name: "publisher", CallTimeout: 1},
+		ferry.Carry("IPublisher", &holder{slots: slots}, true))
+	pub := <-slots
+	go ferry.Startup("127.0.0.1:55555", "room", ferry.Carry("IRoom", &room{name: "room"}, true))
+	waitReady(t, "127.0.0.1:55555", 4)
+	time.Sleep(50 * time.Millisecond)
+
+	published := make(chan error, 1)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if err := pub.Publish("news", i); nil != err {
+				published <- err
+				return
+			}
+		}
+		published <- nil
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// The messages waiting for the stuck subscriber don't hold the other
+	// packets of both docks.
+	for name, s := range map[string]ferry.ISlot{"laggard": sub, "publisher": pub} {
+		if r, err := s.CallWithResult("IRoom", "Name"); nil != err || "room" != r[0].(string) {
+			t.Errorf("Call from [%s] returns %v with error [%v], expect [room]", name, r, err)
+		}
+	}
+
+	close(l.release)
+	for n := 0; n < 1000; n++ {
+		select {
+		case msg := <-l.received:
+			if n != msg {
+				t.Fatalf("Received [%d], expect [%d]", msg, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Received %d messages, expect 1000", n)
+		}
+	}
+	if err := <-published; nil != err {
+		t.Error(err)
+	}
+
+	ferry.Close()
+}
+
+type bomb struct {
+	ferry.Feature
+	name string
+}
+
+func (b *bomb) Explode() {
+	panic(b.name + " exploded")
+}
+
+func (b *bomb) Name() string {
+	return b.name
+}
+
+func (b *bomb) Defuse(wire int) (string, error) {
+	if 1 != wire {
+		return "", errors.New("wrong wire")
+	}
+
+	return b.name, nil
+}
+
+type sapper struct {
+	ferry.Feature
+	t  *testing.T
+	wg *sync.WaitGroup
+}
+
+func (p *sapper) OnStart(s ferry.ISlot) {
+	defer p.wg.Done()
+
+	for _, name := range []string{"IBomb@bomb", "ILocalBomb"} {
+		err := s.Call(name, "Explode")
+		e, ok := err.(*ferry.Error)
+		if !ok || ferry.CodeInternal != e.Code || !strings.Contains(e.Details, "Explode") {
+			p.t.Errorf("Call to panicking method of [%s] should fail with stack: %v", name, err)
+			continue
+		}
+
+		if r, err := s.CallWithResult(name, "Name"); nil != err {
+			p.t.Errorf("Call to [%s] after panic failed: %v", name, err)
+		} else if "bomb" != r[0].(string) {
+			p.t.Errorf("Result [%v], expect [bomb]", r[0])
+		}
+
+		if _, err := s.CallWithResult(name, "Defuse", 2); nil == err || "wrong wire" != err.Error() {
+			p.t.Errorf("Call to [%s] should fail with the returned error: %v", name, err)
+		}
+		if r, err := s.CallWithResult(name, "Defuse", 1); nil != err {
+			p.t.Errorf("Call to [%s] failed: %v", name, err)
+		} else if "bomb" != r[0].(string) || nil != r[1] {
+			p.t.Errorf("Result %v, expect [bomb <nil>]", r)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go ferry.Serve("127.0.0.1:55555")
+	go ferry.Startup("127.0.0.1:55555", "bomb", ferry.Carry("IBomb", &bomb{name: "bomb"}, true))
+	go ferry.Startup("127.0.0.1:55555", "sapper",
+		ferry.Carry("ILocalBomb", &bomb{name: "bomb"}, false),
+		ferry.Carry("ISapper", &sapper{t: t, wg: &wg}, true))
+
+	wg.Wait()
+
+	ferry.Close()
+}
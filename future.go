@@ -0,0 +1,92 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+func newFuture() *future {
+	return &future{done: make(chan struct{})}
+}
+
+// future is the result of an async call.
+type future struct {
+	done   chan struct{}
+	result []interface{}
+	err    error
+}
+
+func (f *future) Wait() ([]interface{}, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+func (f *future) WaitTimeout(timeout float32) ([]interface{}, error) {
+	timer := time.NewTimer(seconds(timeout))
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-timer.C:
+		return nil, newError(CodeTimeout, "Future wait timeout!")
+	}
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+// resolve completes the future, and it must be called only once.
+func (f *future) resolve(result []interface{}, err error) {
+	f.result = result
+	f.err = err
+	close(f.done)
+}
+
+// goCall runs the call in a new routine and returns its future.
+func goCall(call func() ([]interface{}, error)) IFuture {
+	f := newFuture()
+	go func() {
+		f.resolve(call())
+	}()
+
+	return f
+}
+
+func waitAll(futures []IFuture) ([][]interface{}, error) {
+	results := make([][]interface{}, len(futures))
+	var first error
+	for i, f := range futures {
+		result, err := f.Wait()
+		results[i] = result
+		if nil != err && nil == first {
+			first = err
+		}
+	}
+
+	return results, first
+}
+
+func waitAny(futures []IFuture) (int, []interface{}, error) {
+	if 0 == len(futures) {
+		return -1, nil, fmt.Errorf("No future to wait!")
+	}
+
+	cases := make([]reflect.SelectCase, len(futures))
+	for i, f := range futures {
+		cases[i] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(f.Done()),
+		}
+	}
+
+	i, _, _ := reflect.Select(cases)
+	result, err := futures[i].Wait()
+	return i, result, err
+}
@@ -8,8 +8,11 @@ import (
 	"container/list"
 	"fmt"
 	"log"
-	"strings"
+	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/muguangyi/ferry/network"
 )
@@ -19,21 +22,57 @@ const (
 	cMaxPortRange int = 49000
 )
 
-func newHub() *hub {
+func newHub(conf HubConfig) *hub {
+	conf.normalize()
+
+	// Replicated hubs allocate ports in turn by the order of their addrs, so
+	// they never allocate the same port before synced.
+	addrs := append([]string{conf.Addr}, conf.Peers...)
+	sort.Strings(addrs)
+	shard := sort.SearchStrings(addrs, conf.Addr)
+
 	return &hub{
+		conf:        conf,
+		shard:       shard,
+		shards:      len(addrs),
+		guard:       newGuard(conf.Secret, conf.Tokens),
 		docks:       make(map[string]*list.List),
-		assignPorts: make(map[string]int),
+		berths:      make(map[network.IPeer]*berth),
+		mirrors:     make(map[string]*berth),
+		hubs:        make(map[network.IPeer]string),
+		queries:     make(map[string]*list.List),
+		watchers:    make(map[string]map[network.IPeer]bool),
+		topics:      make(map[string]map[network.IPeer]*outlet),
+		balancers:   make(map[string]IBalancer),
+		assignPorts: make(map[string]map[int]bool),
 		blackPorts:  make(map[int]bool),
+		dirty:       make(chan bool, 1),
+		closeSig:    make(chan bool),
 	}
 }
 
 type hub struct {
+	conf             HubConfig
+	shard            int // index of the ports allocated by this hub.
+	shards           int
+	guard            *guard
 	socket           network.ISocket
 	docksMutex       sync.Mutex
 	docks            map[string]*list.List
+	berths           map[network.IPeer]*berth
+	mirrors          map[string]*berth // docks registered to other hubs.
+	hubs             map[network.IPeer]string
+	links            []*link
+	queries          map[string]*list.List
+	watchers         map[string]map[network.IPeer]bool
+	topics           map[string]map[network.IPeer]*outlet // subscribers of topics.
+	balancers        map[string]IBalancer
 	assignPortsMutex sync.Mutex
-	assignPorts      map[string]int
+	assignPorts      map[string]map[int]bool
 	blackPorts       map[int]bool
+	dirty            chan bool     // registry changes not in snapshot.
+	kept             chan struct{} // closed when snapshot writer quits.
+	closeSig         chan bool
 }
 
 type stub struct {
@@ -42,48 +81,146 @@ type stub struct {
 	ready bool
 }
 
+// query presents a pending query waiting for a ready stub.
+type query struct {
+	peer  network.IPeer
+	dock  string // name or id of the target dock, empty means any dock.
+	index int64  // index of the call querying for.
+	timer *time.Timer
+	done  bool
+}
+
+// berth presents a registered dock and its liveness.
+type berth struct {
+	name   string
+	id     string
+	addr   string
+	ip     string
+	port   int
+	slots  []string
+	beat   time.Time
+	since  time.Time // when the dock registered.
+	origin string    // hub which the dock registered to, only for mirror.
+	expire time.Time // when to remove if not reclaimed, only for mirror.
+}
+
 func (h *hub) Close() {
+	conceal(h)
+	close(h.closeSig)
+	if nil != h.kept {
+		<-h.kept
+	}
+	for _, l := range h.links {
+		l.close()
+	}
 	h.socket.Close()
 	h.socket = nil
+
+	// The docks closed along may be evicted meanwhile.
+	h.docksMutex.Lock()
 	h.docks = nil
+	h.docksMutex.Unlock()
+	h.assignPortsMutex.Lock()
 	h.assignPorts = nil
 	h.blackPorts = nil
+	h.assignPortsMutex.Unlock()
 }
 
 func (h *hub) OnConnected(peer network.IPeer) {
 	log.Printf("[%s] dock is comming to hub [%s]...", peer.RemoteAddr(), peer.LocalAddr())
+	if h.guard.enabled() {
+		h.guard.challenge(peer)
+	}
 }
 
 func (h *hub) OnClosed(peer network.IPeer) {
+	h.guard.forget(peer)
+	h.unsubscribe(peer)
+	h.leave(peer, nil)
+	if h.orphan(peer) {
+		log.Printf("[%s] hub left hub [%s].", peer.RemoteAddr(), peer.LocalAddr())
+		return
+	}
 
+	log.Printf("[%s] dock left hub [%s].", peer.RemoteAddr(), peer.LocalAddr())
+	h.evict(peer)
 }
 
 func (h *hub) OnPacket(peer network.IPeer, obj interface{}) {
 	pack := obj.(*packer)
+	h.touch(peer)
+
+	// Only auth answer, and inspecting if it's public, are accepted from
+	// peers not authenticated.
+	if h.guard.enabled() && !h.guard.passed(peer) {
+		switch pack.Id {
+		case cAuth:
+			if !h.guard.verify(peer, pack.P.(*protoAuth)) {
+				log.Printf("[%s] authentication failed.", peer.RemoteAddr())
+				h.guard.reject(peer)
+			}
+			return
+		case cInspectRequest:
+			if !h.conf.PublicInspect {
+				h.guard.reject(peer)
+				return
+			}
+		default:
+			h.guard.reject(peer)
+			return
+		}
+	}
+
 	switch pack.Id {
 	case cRegisterRequest:
 		{
 			req := pack.P.(*protoRegisterRequest)
+			if h.extend(peer, req.Slots) {
+				// Slots carried by the running dock.
+				return
+			}
 
-			addr := pickIP(peer.RemoteAddr().String())
-			port := h.allocate(addr)
+			ip := pickIP(peer.RemoteAddr().String())
+			port := 0
+			var addr string
+			if "" != req.Addr {
+				// Dock has bound the port itself, or it is registered before
+				// and failover from other hub.
+				addr = fillIP(req.Addr, ip)
+				ip, port = pickIP(addr), pickPort(addr)
+				h.reserve(ip, port)
+			} else if h.conf.DisableAllocator {
+				peer.Send(&packer{
+					Id: cError,
+					P: &protoError{
+						Error: "Dock must advertise addr as hub port allocator is disabled!",
+					},
+				})
+				peer.Close()
+				return
+			} else {
+				port = h.allocate(ip)
+				addr = fmt.Sprintf("%s:%d", ip, port)
+			}
 
-			addr = fmt.Sprintf("%s:%d", addr, port)
-			for _, v := range req.Slots {
-				h.docksMutex.Lock()
-				stubs, ok := h.docks[v]
-				if !ok {
-					stubs = list.New()
-					h.docks[v] = stubs
-				}
-				stubs.PushBack(&stub{addr: addr, peer: peer, ready: false})
-				h.docksMutex.Unlock()
+			h.docksMutex.Lock()
+			if m, ok := h.mirrors[addr]; ok {
+				// The dock moves from other hub to here.
+				delete(h.mirrors, addr)
+				h.unstub(m.slots, addr, nil)
 			}
+			h.stub(req.Slots, addr, peer)
+			b := &berth{name: req.Name, id: req.Id, addr: addr, ip: ip, port: port, slots: req.Slots, beat: time.Now(), since: time.Now()}
+			h.berths[peer] = b
+			h.sync(cSyncAdd, b, req.Slots)
+			h.persist()
+			h.docksMutex.Unlock()
 
 			resp := &packer{
 				Id: cRegisterResponse,
 				P: &protoRegisterResponse{
-					Port: port,
+					Port: pickPort(addr),
+					Addr: addr,
 				},
 			}
 			peer.Send(resp)
@@ -91,94 +228,499 @@ func (h *hub) OnPacket(peer network.IPeer, obj interface{}) {
 	case cReady:
 		{
 			req := pack.P.(*protoReady)
-			for _, slot := range req.Slots {
-				h.docksMutex.Lock()
-				stubs, ok := h.docks[slot]
-				h.docksMutex.Unlock()
-				if ok {
-					for i := stubs.Front(); i != nil; i = i.Next() {
-						stub := i.Value.(*stub)
-						if stub.peer == peer {
-							stub.ready = true
-							break
-						}
-					}
-				}
+			h.docksMutex.Lock()
+			if b, ok := h.berths[peer]; ok {
+				h.ready(req.Slots, b.addr, peer)
+				h.sync(cSyncReady, b, req.Slots)
 			}
+			h.docksMutex.Unlock()
 		}
 	case cQueryRequest:
 		{
-			go func() {
-				req := pack.P.(*protoQueryRequest)
-				for {
-					h.docksMutex.Lock()
-					docks, ok := h.docks[req.Slot]
-					h.docksMutex.Unlock()
-					if ok {
-						// Loop from back to front, means the 'new' one will
-						// be serve at first.
-						for i := docks.Back(); i != nil; i = i.Prev() {
-							stub := i.Value.(*stub)
-							if stub.ready {
-								h.respondQueryImme(peer, stub.addr)
-								return
-							}
-						}
-					}
-				}
-			}()
+			req := pack.P.(*protoQueryRequest)
+			h.docksMutex.Lock()
+			if stub := h.pick(req.Slot, req.Dock, peer); nil != stub {
+				h.respondQueryImme(peer, req.Slot, req.Dock, req.Index, stub)
+			} else {
+				h.pend(peer, req.Slot, req.Dock, req.Index)
+			}
+			h.docksMutex.Unlock()
+		}
+	case cListRequest:
+		{
+			h.list(peer, pack.P.(*protoListRequest))
+		}
+	case cDeregister:
+		{
+			h.shrink(peer, pack.P.(*protoDeregister).Slots)
+		}
+	case cDrain:
+		{
+			h.docksMutex.Lock()
+			if b, ok := h.berths[peer]; ok {
+				h.unready(b.slots, b.addr, peer)
+				h.sync(cSyncDrain, b, b.slots)
+			}
+			h.docksMutex.Unlock()
+		}
+	case cSync:
+		{
+			h.mirror(peer, pack.P.(*protoSync))
+		}
+	case cWatch:
+		{
+			h.subscribe(peer, pack.P.(*protoWatch).Slots)
+		}
+	case cSubscribe:
+		{
+			h.join(peer, pack.P.(*protoSubscribe).Topics)
+		}
+	case cUnsubscribe:
+		{
+			h.leave(peer, pack.P.(*protoUnsubscribe).Topics)
+		}
+	case cPublish:
+		{
+			h.publish(peer, pack.P.(*protoPublish))
+		}
+	case cPublishCredit:
+		{
+			h.credited(peer, pack.P.(*protoPublishCredit))
+		}
+	case cInspectRequest:
+		{
+			peer.Send(&packer{
+				Id: cInspectResponse,
+				P: &protoInspectResponse{
+					Docks: h.inspect(),
+				},
+			})
 		}
 	}
 }
 
-func (h *hub) run(hubAddr string, blackPorts ...int) {
-	for _, p := range blackPorts {
+func (h *hub) run() {
+	for _, p := range h.conf.BlackPorts {
 		h.blackPorts[p] = true
 	}
 
 	network.ExtendSerializer("seek", newSerializer())
+	h.restore()
+	if "" != h.conf.Snapshot {
+		h.kept = make(chan struct{})
+		go h.keep()
+	}
+
+	// Links are ready before docks come, as their changes are synced
+	// through.
+	for _, addr := range h.conf.Peers {
+		h.links = append(h.links, newLink(h, addr))
+	}
 
-	h.socket = network.NewSocket(hubAddr, "seek", h)
+	h.socket = network.NewSocket(h.conf.Addr, "seek", h)
 	h.socket.Listen()
+	expose(h)
+
+	for _, l := range h.links {
+		go l.run()
+	}
+
+	go h.watch()
 }
 
-func (h *hub) allocate(addr string) int {
-	h.assignPortsMutex.Lock()
-	defer h.assignPortsMutex.Unlock()
+// watch checks heartbeats of all docks periodically, and evict the docks
+// which are timeout.
+func (h *hub) watch() {
+	ticker := time.NewTicker(seconds(h.conf.HeartbeatTimeout / 2))
+	defer ticker.Stop()
 
-	port := h.assignPorts[addr]
 	for {
-		if 0 == port {
-			port = cOriginPort
+		select {
+		case <-h.closeSig:
+			return
+		case now := <-ticker.C:
+			timeout := seconds(h.conf.HeartbeatTimeout)
+			expired := make([]network.IPeer, 0)
+			h.docksMutex.Lock()
+			for peer, b := range h.berths {
+				if now.Sub(b.beat) > timeout {
+					expired = append(expired, peer)
+				}
+			}
+			h.sweep(now)
+			h.docksMutex.Unlock()
+
+			for _, peer := range expired {
+				log.Printf("[%s] dock heartbeat timeout, evicted from hub.", peer.RemoteAddr())
+				h.evict(peer)
+				peer.Close()
+			}
 		}
+	}
+}
+
+func (h *hub) touch(peer network.IPeer) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	if b, ok := h.berths[peer]; ok {
+		b.beat = time.Now()
+	}
+}
+
+// evict marks all stubs of the dock as not ready, removes them from hub and
+// releases the port allocated to the dock.
+func (h *hub) evict(peer network.IPeer) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	b, ok := h.berths[peer]
+	if !ok {
+		return
+	}
+	delete(h.berths, peer)
+	if 0 != b.port {
+		h.release(b.ip, b.port)
+	}
+
+	for slot := range h.queries {
+		h.cancel(slot, peer)
+	}
 
-		port += 1
+	h.unstub(b.slots, b.addr, peer)
+	h.sync(cSyncRemove, b, b.slots)
+	h.persist()
+}
 
-		if !h.blackPorts[port] {
-			break
+// stub adds stubs of slots for the dock at addr, and peer is nil if the dock
+// is registered to other hub.
+func (h *hub) stub(slots []string, addr string, peer network.IPeer) {
+	for _, v := range slots {
+		stubs, ok := h.docks[v]
+		if !ok {
+			stubs = list.New()
+			h.docks[v] = stubs
 		}
+		stubs.PushBack(&stub{addr: addr, peer: peer, ready: false})
+		h.notify(SlotAdded, v, addr)
 	}
+}
 
-	if port > cMaxPortRange {
-		log.Fatal("Out of port max range!")
+// ready marks stubs of slots for the dock at addr as ready.
+func (h *hub) ready(slots []string, addr string, peer network.IPeer) {
+	for _, slot := range slots {
+		stubs, ok := h.docks[slot]
+		if ok {
+			for i := stubs.Front(); i != nil; i = i.Next() {
+				stub := i.Value.(*stub)
+				if stub.peer == peer && stub.addr == addr {
+					if !stub.ready {
+						stub.ready = true
+						h.notify(SlotReady, slot, addr)
+					}
+					h.wake(slot)
+					break
+				}
+			}
+		}
 	}
+}
 
-	h.assignPorts[addr] = port
+// unready marks stubs of slots for the dock at addr as not ready, so no more
+// queries are routed to them.
+func (h *hub) unready(slots []string, addr string, peer network.IPeer) {
+	for _, slot := range slots {
+		stubs, ok := h.docks[slot]
+		if ok {
+			for i := stubs.Front(); i != nil; i = i.Next() {
+				stub := i.Value.(*stub)
+				if stub.peer == peer && stub.addr == addr && stub.ready {
+					stub.ready = false
+					h.notify(SlotDraining, slot, addr)
+				}
+			}
+		}
+	}
+}
+
+// unstub marks stubs of slots for the dock at addr as not ready and removes
+// them.
+func (h *hub) unstub(slots []string, addr string, peer network.IPeer) {
+	for _, slot := range slots {
+		stubs, ok := h.docks[slot]
+		if !ok {
+			continue
+		}
 
-	return port
+		for i := stubs.Front(); i != nil; {
+			next := i.Next()
+			stub := i.Value.(*stub)
+			if stub.peer == peer && stub.addr == addr {
+				stub.ready = false
+				stubs.Remove(i)
+				h.notify(SlotRemoved, slot, addr)
+			}
+			i = next
+		}
+
+		if 0 == stubs.Len() {
+			delete(h.docks, slot)
+		}
+	}
 }
 
-func (h *hub) respondQueryImme(peer network.IPeer, dockAddr string) {
-	resp := &packer{
-		Id: cQueryResponse,
-		P: &protoQueryResponse{
-			DockAddr: dockAddr,
-		},
+// pick returns a ready stub for slot chosen by the balancer of the slot, or
+// nil if there is no one. Only the stub on the dock with name or id is picked
+// if dock is not empty.
+func (h *hub) pick(slot string, dock string, peer network.IPeer) *stub {
+	stubs, ok := h.docks[slot]
+	if !ok {
+		return nil
+	}
+
+	ready := make([]*stub, 0, stubs.Len())
+	instances := make([]Instance, 0, stubs.Len())
+	for i := stubs.Front(); i != nil; i = i.Next() {
+		stub := i.Value.(*stub)
+		if stub.ready && ("" == dock || h.match(stub, dock)) {
+			ready = append(ready, stub)
+			instances = append(instances, h.instance(stub))
+		}
+	}
+	if 0 == len(ready) {
+		return nil
+	}
+
+	key := peer.RemoteAddr().String()
+	if b, ok := h.berths[peer]; ok {
+		key = b.addr
+	}
+
+	index := h.balancer(slot).Pick(key, instances)
+	if index < 0 || index >= len(ready) {
+		index = 0
+	}
+
+	return ready[index]
+}
+
+// instance returns the dock of stub to be picked by balancer.
+func (h *hub) instance(stub *stub) Instance {
+	v := Instance{Addr: stub.addr}
+	if b, ok := h.berthOf(stub); ok {
+		v.Name = b.name
+	}
+
+	return v
+}
+
+// match checks if the stub is on the dock with name or id.
+func (h *hub) match(stub *stub, dock string) bool {
+	b, ok := h.berthOf(stub)
+	return ok && (b.name == dock || b.id == dock)
+}
+
+// berthOf returns the dock of stub, which is a mirror if stub is from other
+// hub.
+func (h *hub) berthOf(stub *stub) (*berth, bool) {
+	if nil == stub.peer {
+		b, ok := h.mirrors[stub.addr]
+		return b, ok
+	}
+
+	b, ok := h.berths[stub.peer]
+	return b, ok
+}
+
+func (h *hub) balancer(slot string) IBalancer {
+	b, ok := h.balancers[slot]
+	if !ok {
+		b = h.conf.Balancers[slot]
+		if nil == b {
+			b = RoundRobin()
+		}
+		h.balancers[slot] = b
+	}
+
+	return b
+}
+
+// pend holds the query until a stub of the slot is ready, or respond error
+// when timeout.
+func (h *hub) pend(peer network.IPeer, slot string, dock string, index int64) {
+	queries, ok := h.queries[slot]
+	if !ok {
+		queries = list.New()
+		h.queries[slot] = queries
 	}
-	peer.Send(resp)
+
+	q := &query{peer: peer, dock: dock, index: index}
+	e := queries.PushBack(q)
+	q.timer = time.AfterFunc(seconds(h.conf.QueryTimeout), func() {
+		h.docksMutex.Lock()
+		defer h.docksMutex.Unlock()
+
+		if !q.done {
+			q.done = true
+			queries.Remove(e)
+			if 0 == queries.Len() && h.queries[slot] == queries {
+				delete(h.queries, slot)
+			}
+
+			target := joinTarget(slot, dock)
+			peer.Send(&packer{
+				Id: cError,
+				P: &protoError{
+					Slot:  target,
+					Error: fmt.Sprintf("[%s] slot query timeout!", target),
+				},
+			})
+		}
+	})
+}
+
+// wake responds the pending queries for slot with ready stubs, and the ones
+// targeting other docks keep waiting.
+func (h *hub) wake(slot string) {
+	queries, ok := h.queries[slot]
+	if !ok {
+		return
+	}
+
+	for i := queries.Front(); i != nil; {
+		next := i.Next()
+		q := i.Value.(*query)
+		if stub := h.pick(slot, q.dock, q.peer); nil != stub {
+			q.timer.Stop()
+			q.done = true
+			queries.Remove(i)
+			h.respondQueryImme(q.peer, slot, q.dock, q.index, stub)
+		}
+		i = next
+	}
+
+	if 0 == queries.Len() {
+		delete(h.queries, slot)
+	}
+}
+
+// cancel drops all pending queries for slot from peer.
+func (h *hub) cancel(slot string, peer network.IPeer) {
+	queries, ok := h.queries[slot]
+	if !ok {
+		return
+	}
+
+	for i := queries.Front(); i != nil; {
+		next := i.Next()
+		q := i.Value.(*query)
+		if q.peer == peer {
+			q.timer.Stop()
+			q.done = true
+			queries.Remove(i)
+		}
+		i = next
+	}
+
+	if 0 == queries.Len() {
+		delete(h.queries, slot)
+	}
+}
+
+func (h *hub) allocate(ip string) int {
+	h.assignPortsMutex.Lock()
+	defer h.assignPortsMutex.Unlock()
+
+	ports, ok := h.assignPorts[ip]
+	if !ok {
+		ports = make(map[int]bool)
+		h.assignPorts[ip] = ports
+	}
+
+	// Pick the lowest port which is not used, so the released ports could be
+	// reused by new docks.
+	for port := cOriginPort + 1 + h.shard; port <= cMaxPortRange; port += h.shards {
+		if !h.blackPorts[port] && !ports[port] {
+			ports[port] = true
+			return port
+		}
+	}
+
+	log.Fatal("Out of port max range!")
+	return 0
+}
+
+func (h *hub) reserve(ip string, port int) {
+	h.assignPortsMutex.Lock()
+	defer h.assignPortsMutex.Unlock()
+
+	ports, ok := h.assignPorts[ip]
+	if !ok {
+		ports = make(map[int]bool)
+		h.assignPorts[ip] = ports
+	}
+	ports[port] = true
+}
+
+func (h *hub) release(ip string, port int) {
+	h.assignPortsMutex.Lock()
+	defer h.assignPortsMutex.Unlock()
+
+	ports, ok := h.assignPorts[ip]
+	if ok {
+		delete(ports, port)
+		if 0 == len(ports) {
+			delete(h.assignPorts, ip)
+		}
+	}
+}
+
+func (h *hub) respondQueryImme(peer network.IPeer, slot string, dock string, index int64, stub *stub) {
+	resp := &protoQueryResponse{
+		Slot:     slot,
+		Dock:     dock,
+		DockAddr: stub.addr,
+		Index:    index,
+	}
+	if b, ok := h.berthOf(stub); ok {
+		resp.DockId = b.id
+	}
+
+	peer.Send(&packer{
+		Id: cQueryResponse,
+		P:  resp,
+	})
 }
 
 func pickIP(addr string) string {
-	info := strings.Split(addr, ":")
-	return info[0]
+	host, _, err := net.SplitHostPort(addr)
+	if nil != err {
+		return addr
+	}
+
+	return host
+}
+
+func pickPort(addr string) int {
+	_, port, err := net.SplitHostPort(addr)
+	if nil != err {
+		return 0
+	}
+
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// fillIP completes the advertised addr with ip if it has no valid host.
+func fillIP(addr string, ip string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if nil != err {
+		return addr
+	}
+
+	if "" == host || "0.0.0.0" == host || "::" == host {
+		host = ip
+	}
+
+	return net.JoinHostPort(host, port)
 }
@@ -0,0 +1,199 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cInspectTimeout float32 = 3.0
+)
+
+// DockInfo describes a dock known by hub.
+type DockInfo struct {
+	// Name of the dock given when startup.
+	Name string
+
+	// ID of the dock instance, unique in the cluster.
+	ID string
+
+	// Addr for other docks to connect.
+	Addr string
+
+	// Hub which the dock registered to.
+	Hub string
+
+	// Since when the dock registered.
+	Since time.Time
+
+	// Slots the dock carries.
+	Slots []SlotInfo
+}
+
+// SlotInfo describes a slot instance in dock.
+type SlotInfo struct {
+	// ID of the slot.
+	ID string
+
+	// Ready to serve or not.
+	Ready bool
+}
+
+var (
+	exposedMutex sync.Mutex
+	exposed      map[string]*hub = make(map[string]*hub)
+)
+
+func expose(h *hub) {
+	exposedMutex.Lock()
+	defer exposedMutex.Unlock()
+
+	exposed[h.conf.Addr] = h
+}
+
+func conceal(h *hub) {
+	exposedMutex.Lock()
+	defer exposedMutex.Unlock()
+
+	if exposed[h.conf.Addr] == h {
+		delete(exposed, h.conf.Addr)
+	}
+}
+
+// inspect collects all docks known by hub, sorted by addr.
+func (h *hub) inspect() []DockInfo {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	docks := make([]DockInfo, 0, len(h.berths)+len(h.mirrors))
+	for p, b := range h.berths {
+		docks = append(docks, h.describe(b, p, h.conf.Addr))
+	}
+	for _, m := range h.mirrors {
+		docks = append(docks, h.describe(m, nil, m.origin))
+	}
+
+	sort.Slice(docks, func(i, j int) bool {
+		return docks[i].Addr < docks[j].Addr
+	})
+
+	return docks
+}
+
+func (h *hub) describe(b *berth, peer network.IPeer, origin string) DockInfo {
+	d := DockInfo{
+		Name:  b.name,
+		ID:    b.id,
+		Addr:  b.addr,
+		Hub:   origin,
+		Since: b.since,
+		Slots: make([]SlotInfo, 0, len(b.slots)),
+	}
+
+	for _, slot := range b.slots {
+		info := SlotInfo{ID: slot}
+		if stubs, ok := h.docks[slot]; ok {
+			for i := stubs.Front(); i != nil; i = i.Next() {
+				stub := i.Value.(*stub)
+				if stub.peer == peer && stub.addr == b.addr {
+					info.Ready = stub.ready
+					break
+				}
+			}
+		}
+		d.Slots = append(d.Slots, info)
+	}
+
+	sort.Slice(d.Slots, func(i, j int) bool {
+		return d.Slots[i].ID < d.Slots[j].ID
+	})
+
+	return d
+}
+
+// inspector queries docks from a remote hub, and answers the challenge of
+// the hub first if having secret.
+type inspector struct {
+	name   string
+	secret string
+	resp   chan []DockInfo
+	fail   chan error
+}
+
+func (i *inspector) OnConnected(peer network.IPeer) {
+	if "" == i.secret {
+		i.request(peer)
+	}
+}
+
+func (i *inspector) OnClosed(peer network.IPeer) {
+}
+
+func (i *inspector) OnPacket(peer network.IPeer, obj interface{}) {
+	pack := obj.(*packer)
+	switch pack.Id {
+	case cChallenge:
+		if "" != i.secret {
+			answer(peer, i.name, i.secret, pack.P.(*protoChallenge))
+			i.request(peer)
+		}
+	case cInspectResponse:
+		select {
+		case i.resp <- pack.P.(*protoInspectResponse).Docks:
+		default:
+		}
+	case cError:
+		select {
+		case i.fail <- fmt.Errorf("[%s] inspect hub failed: %s", peer.RemoteAddr(), pack.P.(*protoError).Error):
+		default:
+		}
+	}
+}
+
+func (i *inspector) request(peer network.IPeer) {
+	peer.Send(&packer{
+		Id: cInspectRequest,
+		P:  &protoInspectRequest{},
+	})
+}
+
+func inspect(hubAddr string, name string, secret string) ([]DockInfo, error) {
+	exposedMutex.Lock()
+	h := exposed[hubAddr]
+	exposedMutex.Unlock()
+	if nil != h {
+		return h.inspect(), nil
+	}
+
+	network.ExtendSerializer("ferry", newSerializer())
+
+	i := &inspector{
+		name:   name,
+		secret: secret,
+		resp:   make(chan []DockInfo, 1),
+		fail:   make(chan error, 1),
+	}
+	socket := network.NewSocket(hubAddr, "ferry", i)
+	err := socket.Dial()
+	if nil != err {
+		return nil, err
+	}
+	defer socket.Close()
+
+	select {
+	case docks := <-i.resp:
+		return docks, nil
+	case err := <-i.fail:
+		return nil, err
+	case <-time.After(seconds(cInspectTimeout)):
+		return nil, fmt.Errorf("[%s] inspect hub timeout!", hubAddr)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cLinkRetryInterval float32 = 1.0
+)
+
+func newLink(h *hub, addr string) *link {
+	return &link{
+		hub:      h,
+		addr:     addr,
+		outlets:  make(map[string]*outlet),
+		closed:   make(chan bool, 1),
+		closeSig: make(chan bool),
+	}
+}
+
+// link is the connection from hub to a peer hub, and all registry changes of
+// the hub are synced through it.
+type link struct {
+	sync.Mutex
+	hub      *hub
+	addr     string
+	socket   network.ISocket
+	authed   bool
+	outlets  map[string]*outlet // messages of topics relayed to peer hub.
+	closed   chan bool
+	closeSig chan bool
+}
+
+func (l *link) OnConnected(peer network.IPeer) {
+	log.Printf("[%s] hub linked to hub [%s].", peer.LocalAddr(), peer.RemoteAddr())
+
+	// Peer hubs share the same secret, so wait for challenge if there is.
+	if "" == l.hub.conf.Secret {
+		l.pass(peer)
+	}
+}
+
+func (l *link) OnClosed(peer network.IPeer) {
+	l.Lock()
+	l.authed = false
+	l.Unlock()
+
+	l.closed <- true
+}
+
+func (l *link) OnPacket(peer network.IPeer, obj interface{}) {
+	pack := obj.(*packer)
+	switch pack.Id {
+	case cChallenge:
+		answer(peer, l.hub.conf.Addr, l.hub.conf.Secret, pack.P.(*protoChallenge))
+		l.pass(peer)
+	case cPublishCredit:
+		p := pack.P.(*protoPublishCredit)
+		l.outlet(p.Topic).grant(p.Credit)
+	case cError:
+		log.Printf("[%s] error from hub [%s]: %s", peer.LocalAddr(), peer.RemoteAddr(), pack.P.(*protoError).Error)
+	}
+}
+
+// pass starts syncing registry to peer hub, and the credits to relay are
+// full for the new connection.
+func (l *link) pass(peer network.IPeer) {
+	l.Lock()
+	l.authed = true
+	for _, o := range l.outlets {
+		o.grant(cTopicWindow)
+	}
+	l.Unlock()
+
+	l.hub.snapshot(peer)
+}
+
+// run keeps the link connected until closed.
+func (l *link) run() {
+	for {
+		socket := network.NewSocket(l.addr, "seek", l)
+		l.Lock()
+		l.socket = socket
+		l.Unlock()
+
+		if err := socket.Dial(); nil == err {
+			select {
+			case <-l.closeSig:
+				return
+			case <-l.closed:
+			}
+		}
+
+		select {
+		case <-l.closeSig:
+			return
+		case <-time.After(seconds(cLinkRetryInterval)):
+		}
+	}
+}
+
+func (l *link) send(obj interface{}) {
+	l.Lock()
+	defer l.Unlock()
+
+	if nil != l.socket && l.authed {
+		l.socket.Send(obj)
+	}
+}
+
+// push sends obj to peer hub like send, but waits if peer hub is busy.
+func (l *link) push(obj interface{}) {
+	l.Lock()
+	socket := l.socket
+	authed := l.authed
+	l.Unlock()
+
+	if nil != socket && authed {
+		if err := socket.Push(obj); nil != err {
+			log.Printf("[%s] push to hub failed: %s", l.addr, err)
+		}
+	}
+}
+
+// outlet returns the outlet relaying the messages of topic to peer hub.
+func (l *link) outlet(topic string) *outlet {
+	l.Lock()
+	defer l.Unlock()
+
+	o, ok := l.outlets[topic]
+	if !ok {
+		o = newOutlet(topic, true, func(obj interface{}) error {
+			l.push(obj)
+			return nil
+		})
+		if nil == l.outlets {
+			// Link is closed.
+			o.stop()
+			return o
+		}
+		l.outlets[topic] = o
+	}
+
+	return o
+}
+
+func (l *link) close() {
+	close(l.closeSig)
+
+	l.Lock()
+	socket := l.socket
+	for _, o := range l.outlets {
+		o.stop()
+	}
+	l.outlets = nil
+	l.Unlock()
+
+	if nil != socket {
+		socket.Close()
+	}
+}
+
+// snapshot sends all docks registered to this hub to the peer hub.
+func (h *hub) snapshot(peer network.IPeer) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	for p, b := range h.berths {
+		peer.Send(&packer{
+			Id: cSync,
+			P:  &protoSync{Op: cSyncAdd, Origin: h.conf.Addr, Addr: b.addr, Slots: b.slots, Since: b.since.UnixNano(), Name: b.name, Id: b.id},
+		})
+
+		ready := make([]string, 0)
+		for _, slot := range b.slots {
+			if stubs, ok := h.docks[slot]; ok {
+				for i := stubs.Front(); i != nil; i = i.Next() {
+					stub := i.Value.(*stub)
+					if stub.peer == p && stub.ready {
+						ready = append(ready, slot)
+					}
+				}
+			}
+		}
+		if len(ready) > 0 {
+			peer.Send(&packer{
+				Id: cSync,
+				P:  &protoSync{Op: cSyncReady, Origin: h.conf.Addr, Addr: b.addr, Slots: ready},
+			})
+		}
+	}
+}
+
+// sync broadcasts the registry change to all peer hubs.
+func (h *hub) sync(op uint8, b *berth, slots []string) {
+	for _, l := range h.links {
+		l.send(&packer{
+			Id: cSync,
+			P:  &protoSync{Op: op, Origin: h.conf.Addr, Addr: b.addr, Slots: slots, Since: b.since.UnixNano(), Name: b.name, Id: b.id},
+		})
+	}
+}
+
+// mirror applies the registry change from a peer hub.
+func (h *hub) mirror(peer network.IPeer, req *protoSync) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	h.hubs[peer] = req.Origin
+	for _, b := range h.berths {
+		if b.addr == req.Addr {
+			// The dock is registered to this hub already.
+			return
+		}
+	}
+
+	switch req.Op {
+	case cSyncAdd:
+		if m, ok := h.mirrors[req.Addr]; ok {
+			h.unstub(m.slots, m.addr, nil)
+		}
+		h.mirrors[req.Addr] = &berth{
+			name:   req.Name,
+			id:     req.Id,
+			addr:   req.Addr,
+			ip:     pickIP(req.Addr),
+			port:   pickPort(req.Addr),
+			slots:  req.Slots,
+			since:  time.Unix(0, req.Since),
+			origin: req.Origin,
+		}
+		h.reserve(pickIP(req.Addr), pickPort(req.Addr))
+		h.stub(req.Slots, req.Addr, nil)
+	case cSyncReady:
+		if _, ok := h.mirrors[req.Addr]; ok {
+			h.ready(req.Slots, req.Addr, nil)
+		}
+	case cSyncCarry:
+		if m, ok := h.mirrors[req.Addr]; ok {
+			added := exclude(req.Slots, m.slots)
+			m.slots = append(append([]string{}, m.slots...), added...)
+			h.stub(added, req.Addr, nil)
+		}
+	case cSyncUncarry:
+		if m, ok := h.mirrors[req.Addr]; ok {
+			m.slots = exclude(m.slots, req.Slots)
+			h.unstub(req.Slots, req.Addr, nil)
+		}
+	case cSyncDrain:
+		if _, ok := h.mirrors[req.Addr]; ok {
+			h.unready(req.Slots, req.Addr, nil)
+		}
+	case cSyncRemove:
+		h.unmirror(req.Addr)
+	}
+}
+
+func (h *hub) unmirror(addr string) {
+	m, ok := h.mirrors[addr]
+	if !ok {
+		return
+	}
+
+	delete(h.mirrors, addr)
+	h.release(m.ip, m.port)
+	h.unstub(m.slots, m.addr, nil)
+	if m.origin == h.conf.Addr {
+		h.persist()
+	}
+}
+
+// orphan marks the docks from the closed peer hub as stale, and returns false
+// if the peer is not a hub. Stale docks keep serving until they failover to
+// other hubs or being swept.
+func (h *hub) orphan(peer network.IPeer) bool {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	origin, ok := h.hubs[peer]
+	if !ok {
+		return false
+	}
+	delete(h.hubs, peer)
+
+	expire := time.Now().Add(seconds(h.conf.HeartbeatTimeout))
+	for _, m := range h.mirrors {
+		if m.origin == origin {
+			m.expire = expire
+		}
+	}
+
+	return true
+}
+
+// sweep removes the stale docks which never come back.
+func (h *hub) sweep(now time.Time) {
+	for addr, m := range h.mirrors {
+		if !m.expire.IsZero() && now.After(m.expire) {
+			h.unmirror(addr)
+		}
+	}
+}
@@ -27,13 +27,20 @@ type ISocket interface {
 	Listen()
 
 	// Dial to target socket.
-	Dial()
+	Dial() error
 
 	// Close the socket.
 	Close()
 
 	// Send object to all connected peers.
 	Send(obj interface{})
+
+	// Push object to all connected peers like Send, but wait for the peers
+	// which are busy. It returns the first error of the peers.
+	Push(obj interface{}) error
+
+	// Return the addr socket is listening on, or nil if not listening.
+	Addr() net.Addr
 }
 
 // IPeer interface.
@@ -47,8 +54,15 @@ type IPeer interface {
 	// Return RemoteAddr type.
 	RemoteAddr() net.Addr
 
-	// Send object to peer.
+	// Send object to peer, and it's dropped if too many objects are queued.
 	Send(obj interface{})
+
+	// Push object to peer like Send, but wait if too many objects are queued,
+	// and fail if peer is closed.
+	Push(obj interface{}) error
+
+	// Close the connection to peer.
+	Close()
 }
 
 // ISocketSink interface to handle callback for socket events.
@@ -70,7 +84,9 @@ func NewSocket(addr string, serializer string, sink ISocketSink) ISocket {
 	})
 	s := new(socket)
 	s.addr = addr
+	serializersMutex.RLock()
 	s.serializer = serializers[serializer]
+	serializersMutex.RUnlock()
 	s.sink = sink
 
 	return s
@@ -84,10 +100,14 @@ func Mock(network string) {
 
 // ExtendSerializer extend serializer type with name and handling object.
 func ExtendSerializer(name string, serializer ISerializer) {
+	serializersMutex.Lock()
+	defer serializersMutex.Unlock()
+
 	serializers[name] = serializer
 }
 
 var (
-	serializers map[string]ISerializer = make(map[string]ISerializer)
-	once        sync.Once
+	serializersMutex sync.RWMutex
+	serializers      map[string]ISerializer = make(map[string]ISerializer)
+	once             sync.Once
 )
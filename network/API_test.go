@@ -9,12 +9,14 @@ import (
 	"log"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/muguangyi/ferry/network"
 )
 
 type serverSink struct {
-	wg *sync.WaitGroup
+	wg     *sync.WaitGroup
+	closed chan bool
 }
 
 func (s *serverSink) OnConnected(p network.IPeer) {
@@ -24,7 +26,9 @@ func (s *serverSink) OnConnected(p network.IPeer) {
 }
 
 func (s *serverSink) OnClosed(p network.IPeer) {
-
+	if nil != s.closed {
+		s.closed <- true
+	}
 }
 
 func (s *serverSink) OnPacket(p network.IPeer, obj interface{}) {
@@ -65,3 +69,74 @@ func TestFlow(t *testing.T) {
 	client.Close()
 	server.Close()
 }
+
+func TestRemoteClosed(t *testing.T) {
+	network.Mock("tcp")
+
+	var wg sync.WaitGroup
+
+	wg.Add(4)
+	closed := make(chan bool, 1)
+	server := network.NewSocket("127.0.0.1:55555", "txt", &serverSink{wg: &wg, closed: closed})
+	server.Listen()
+
+	client := network.NewSocket("127.0.0.1:55555", "txt", &clientSink{wg: &wg})
+	client.Dial()
+
+	wg.Wait()
+	client.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("server is not notified when client closed!")
+	}
+
+	server.Close()
+}
+
+type countSink struct {
+	packets chan interface{}
+}
+
+func (c *countSink) OnConnected(p network.IPeer) {
+}
+
+func (c *countSink) OnClosed(p network.IPeer) {
+}
+
+func (c *countSink) OnPacket(p network.IPeer, obj interface{}) {
+	c.packets <- obj
+}
+
+func TestPush(t *testing.T) {
+	network.Mock("tcp")
+
+	packets := make(chan interface{})
+	server := network.NewSocket("127.0.0.1:55555", "txt", &countSink{packets: packets})
+	server.Listen()
+
+	client := network.NewSocket("127.0.0.1:55555", "txt", &countSink{})
+	if err := client.Dial(); nil != err {
+		t.Fatal(err)
+	}
+
+	// The server takes packets slowly, and client waits instead of dropping.
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := client.Push("packet\000"); nil != err {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		select {
+		case <-packets:
+		case <-time.After(time.Second):
+			t.Fatalf("Received %d packets, expect 100", i)
+		}
+	}
+
+	client.Close()
+	server.Close()
+}
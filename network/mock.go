@@ -28,6 +28,14 @@ type netMockTcp struct {
 
 func (n *netMockTcp) Listen(network string, address string) (net.Listener, error) {
 	address = formatAddr(address)
+	listenersMutex.Lock()
+	if strings.HasSuffix(address, ":0") {
+		// Pick a virtual port for ephemeral listening.
+		vport += 1
+		address = fmt.Sprintf("0.0.0.0:%d", vport)
+	}
+	listenersMutex.Unlock()
+
 	listener := &listener{
 		address: &addr{
 			network: network,
@@ -50,20 +58,28 @@ func (n *netMockTcp) Dial(network string, address string) (net.Conn, error) {
 		address = formatAddr(address)
 		timeout := 1 * time.Second
 		for {
-			if 0 == c.status {
+			if status := c.state(); 0 == status {
+				listenersMutex.Lock()
 				listener := listeners[address]
 				if nil != listener {
 					vport += 1
+					c.localAddr.address = fmt.Sprintf("0.0.0.0:%d", vport)
+				}
+				listenersMutex.Unlock()
+				if nil != listener {
+					c.Lock()
 					c.status = 1
 					c.localAddr.network = network
-					c.localAddr.address = fmt.Sprintf("0.0.0.0:%d", vport)
 					c.remoteAddr = listener.address
+					c.Unlock()
 					listener.chanconn <- c
 				}
-			} else if 2 == c.status {
+			} else if 2 == status {
 				break
 			} else if timeout <= 0 {
+				c.Lock()
 				c.status = -1
+				c.Unlock()
 				break
 			}
 
@@ -71,7 +87,7 @@ func (n *netMockTcp) Dial(network string, address string) (net.Conn, error) {
 			time.Sleep(time.Microsecond)
 		}
 
-		connected <- (2 == c.status)
+		connected <- (2 == c.state())
 	}()
 
 	succ := <-connected
@@ -111,8 +127,10 @@ func (l *listener) Accept() (net.Conn, error) {
 	c.remoteAddr = inconn.localAddr
 	c.peer = inconn
 
+	inconn.Lock()
 	inconn.peer = c
 	inconn.status = 2
+	inconn.Unlock()
 
 	l.conns[inconn.localAddr.String()] = inconn
 
@@ -141,6 +159,7 @@ func newConn() *conn {
 }
 
 type conn struct {
+	sync.Mutex
 	status     int
 	localAddr  *addr
 	remoteAddr *addr
@@ -157,9 +176,21 @@ func (c *conn) Read(b []byte) (n int, err error) {
 	}
 }
 
+// state returns the status of connecting.
+func (c *conn) state() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.status
+}
+
 func (c *conn) Write(b []byte) (n int, err error) {
-	if nil != c.peer {
-		c.peer.chanbuf <- b
+	c.Lock()
+	peer := c.peer
+	c.Unlock()
+
+	if nil != peer {
+		peer.chanbuf <- b
 		return len(b), nil
 	} else {
 		return 0, fmt.Errorf("Conn is nil!")
@@ -168,7 +199,9 @@ func (c *conn) Write(b []byte) (n int, err error) {
 
 func (c *conn) Close() error {
 	c.Write(nil)
+	c.Lock()
 	c.peer = nil
+	c.Unlock()
 	return nil
 }
 
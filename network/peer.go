@@ -6,6 +6,7 @@ package network
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"sync"
 )
@@ -15,13 +16,15 @@ const (
 	cRecvBytesSize int = 1024 * 10
 )
 
-func newPeer(conn net.Conn, serializer ISerializer, sink ISocketSink, self bool) *peer {
+func newPeer(socket *socket, conn net.Conn, serializer ISerializer, sink ISocketSink, self bool) *peer {
 	p := new(peer)
+	p.socket = socket
 	p.conn = conn
 	p.serializer = serializer
 	p.sink = sink
 	p.self = self
 	p.sendPackets = make(chan interface{}, cSendChanSize)
+	p.done = make(chan struct{})
 	p.recvBytes = make([]byte, cRecvBytesSize)
 	p.recvBuffer = new(bytes.Buffer)
 
@@ -30,6 +33,7 @@ func newPeer(conn net.Conn, serializer ISerializer, sink ISocketSink, self bool)
 
 type peer struct {
 	sync.Mutex
+	socket      *socket
 	conn        net.Conn
 	serializer  ISerializer
 	sink        ISocketSink
@@ -37,6 +41,8 @@ type peer struct {
 	sendPackets chan interface{}
 	recvBytes   []byte
 	recvBuffer  *bytes.Buffer
+	closed      bool
+	done        chan struct{} // closed when peer is closed.
 }
 
 func (p *peer) IsSelf() bool {
@@ -59,12 +65,33 @@ func (p *peer) Send(obj interface{}) {
 	p.sendPackets <- obj
 }
 
+func (p *peer) Push(obj interface{}) error {
+	select {
+	case <-p.done:
+		return fmt.Errorf("[%s] peer is closed!", p.conn.RemoteAddr())
+	default:
+	}
+
+	select {
+	case p.sendPackets <- obj:
+		return nil
+	case <-p.done:
+		return fmt.Errorf("[%s] peer is closed!", p.conn.RemoteAddr())
+	}
+}
+
+func (p *peer) Close() {
+	p.close()
+}
+
 func (p *peer) run() {
 	// Send routine
 	go func() {
 		for {
 			packet := <-p.sendPackets
-			if nil == packet || nil == p.conn {
+			if nil == packet {
+				// All packets queued before closing are sent.
+				p.conn.Close()
 				break
 			}
 
@@ -81,6 +108,8 @@ func (p *peer) run() {
 		for {
 			size, err := p.conn.Read(p.recvBytes)
 			if nil != err {
+				// Remote side is gone, notify sink.
+				p.close()
 				break
 			}
 
@@ -92,24 +121,45 @@ func (p *peer) run() {
 				}
 
 				obj := p.serializer.Unmarshal(slice)
-				if nil != p.sink {
-					p.sink.OnPacket(p, obj)
+				if sink := p.current(); nil != sink {
+					sink.OnPacket(p, obj)
 				}
 			}
 		}
 	}()
 }
 
+// current returns the sink, or nil if peer is closed.
+func (p *peer) current() ISocketSink {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.sink
+}
+
 func (p *peer) close() {
 	p.Lock()
 	defer p.Unlock()
 
-	p.Send(nil)
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+
+	if nil != p.socket {
+		p.socket.remove(p)
+	}
 
 	if nil != p.sink {
 		p.sink.OnClosed(p)
 		p.sink = nil
 	}
 
-	p.conn.Close()
+	select {
+	case p.sendPackets <- nil:
+		// Sending routine will close conn after all queued packets sent.
+	default:
+		p.conn.Close()
+	}
 }
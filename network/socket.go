@@ -7,6 +7,7 @@ package network
 import (
 	"log"
 	"net"
+	"sync"
 )
 
 type socket struct {
@@ -15,6 +16,7 @@ type socket struct {
 	addr       string
 	listener   net.Listener
 	serializer ISerializer
+	peersMutex sync.Mutex
 	peers      []*peer
 }
 
@@ -40,8 +42,8 @@ func (s *socket) Listen() {
 				return
 			}
 
-			peer := newPeer(conn, s.serializer, s.sink, false)
-			s.peers = append(s.peers, peer)
+			peer := newPeer(s, conn, s.serializer, s.sink, false)
+			s.add(peer)
 
 			if nil != s.sink {
 				s.sink.OnConnected(peer)
@@ -52,33 +54,33 @@ func (s *socket) Listen() {
 	}()
 }
 
-func (s *socket) Dial() {
+func (s *socket) Dial() error {
 	if nil == s.sink {
 		log.Fatal("Please call Init first!")
-		return
+		return nil
 	}
 
 	var err error
 	s.net, err = makeNet("tcp")
 	if nil != err {
-		log.Fatal(err)
-		return
+		return err
 	}
 
 	conn, err := s.net.Dial("tcp", s.addr)
 	if nil != err {
-		log.Fatal(err)
-		return
+		return err
 	}
 
-	peer := newPeer(conn, s.serializer, s.sink, true)
-	s.peers = append(s.peers, peer)
+	peer := newPeer(s, conn, s.serializer, s.sink, true)
+	s.add(peer)
 
 	if nil != s.sink {
 		s.sink.OnConnected(peer)
 	}
 
 	peer.run()
+
+	return nil
 }
 
 func (s *socket) Close() {
@@ -87,14 +89,64 @@ func (s *socket) Close() {
 		s.listener.Close()
 	}
 
-	for _, peer := range s.peers {
+	s.peersMutex.Lock()
+	peers := s.peers
+	s.peers = nil
+	s.peersMutex.Unlock()
+
+	for _, peer := range peers {
 		peer.close()
 	}
-	s.peers = nil
 }
 
 func (s *socket) Send(obj interface{}) {
+	s.peersMutex.Lock()
+	defer s.peersMutex.Unlock()
+
 	for _, peer := range s.peers {
 		peer.Send(obj)
 	}
 }
+
+func (s *socket) Push(obj interface{}) error {
+	s.peersMutex.Lock()
+	peers := make([]*peer, len(s.peers))
+	copy(peers, s.peers)
+	s.peersMutex.Unlock()
+
+	var err error
+	for _, peer := range peers {
+		if e := peer.Push(obj); nil != e && nil == err {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func (s *socket) Addr() net.Addr {
+	if nil != s.listener {
+		return s.listener.Addr()
+	}
+
+	return nil
+}
+
+func (s *socket) add(p *peer) {
+	s.peersMutex.Lock()
+	defer s.peersMutex.Unlock()
+
+	s.peers = append(s.peers, p)
+}
+
+func (s *socket) remove(p *peer) {
+	s.peersMutex.Lock()
+	defer s.peersMutex.Unlock()
+
+	for i, v := range s.peers {
+		if v == p {
+			s.peers = append(s.peers[:i], s.peers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/muguangyi/ferry/codec"
+)
+
+// persist tells the writer to update the snapshot file after the registry
+// changes. It must be called with docksMutex locked.
+func (h *hub) persist() {
+	if "" == h.conf.Snapshot {
+		return
+	}
+
+	// Keep the last snapshot when hub is closing, since all docks will be
+	// evicted.
+	select {
+	case <-h.closeSig:
+		return
+	default:
+	}
+
+	select {
+	case h.dirty <- true:
+	default:
+		// The writer will take the change with the pending one.
+	}
+}
+
+// keep writes the snapshot file whenever the registry changes, and the
+// pending change is written before hub closes.
+func (h *hub) keep() {
+	defer close(h.kept)
+
+	for {
+		select {
+		case <-h.dirty:
+			h.save()
+		case <-h.closeSig:
+			select {
+			case <-h.dirty:
+				h.save()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// save writes all docks registered to this hub into the snapshot file,
+// including the ones restored but not reclaimed yet. Only the copy is taken
+// with docksMutex locked, so routing isn't blocked by disk.
+func (h *hub) save() {
+	h.docksMutex.Lock()
+	docks := make([]interface{}, 0, len(h.berths))
+	for _, b := range h.berths {
+		docks = append(docks, encodeBerth(b))
+	}
+	for _, m := range h.mirrors {
+		if m.origin == h.conf.Addr {
+			docks = append(docks, encodeBerth(m))
+		}
+	}
+	h.docksMutex.Unlock()
+
+	tmp := h.conf.Snapshot + ".tmp"
+	file, err := os.Create(tmp)
+	if nil != err {
+		log.Printf("Create snapshot [%s] failed: %s", tmp, err)
+		return
+	}
+
+	writer := bufio.NewWriter(file)
+	err = codec.NewAny(docks).Encode(writer)
+	if nil == err {
+		err = writer.Flush()
+	}
+	file.Close()
+	if nil == err {
+		err = os.Rename(tmp, h.conf.Snapshot)
+	}
+	if nil != err {
+		log.Printf("Write snapshot [%s] failed: %s", h.conf.Snapshot, err)
+	}
+}
+
+// restore loads docks from the snapshot file. The restored docks are not
+// ready, and wait for the docks to register again before RestoreTimeout.
+func (h *hub) restore() {
+	if "" == h.conf.Snapshot {
+		return
+	}
+
+	file, err := os.Open(h.conf.Snapshot)
+	if nil != err {
+		if !os.IsNotExist(err) {
+			log.Printf("Open snapshot [%s] failed: %s", h.conf.Snapshot, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	any := codec.NewAny(nil)
+	err = any.Decode(bufio.NewReader(file))
+	if nil != err {
+		log.Printf("Read snapshot [%s] failed: %s", h.conf.Snapshot, err)
+		return
+	}
+
+	docks, err := any.Arr()
+	if nil != err {
+		log.Printf("Read snapshot [%s] failed: %s", h.conf.Snapshot, err)
+		return
+	}
+
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	expire := time.Now().Add(seconds(h.conf.RestoreTimeout))
+	restored := 0
+	for _, v := range docks {
+		m, err := decodeBerth(v)
+		if nil != err {
+			log.Printf("Read snapshot [%s] failed: %s", h.conf.Snapshot, err)
+			continue
+		}
+
+		m.origin = h.conf.Addr
+		m.expire = expire
+		h.mirrors[m.addr] = m
+		if 0 != m.port {
+			h.reserve(m.ip, m.port)
+		}
+		h.stub(m.slots, m.addr, nil)
+		restored++
+	}
+
+	log.Printf("[%s] hub restored %d docks from [%s].", h.conf.Addr, restored, h.conf.Snapshot)
+}
+
+func encodeBerth(b *berth) []interface{} {
+	slots := make([]interface{}, len(b.slots))
+	for i, s := range b.slots {
+		slots[i] = s
+	}
+
+	return []interface{}{b.addr, b.ip, b.port, slots, b.since.UnixNano(), b.name, b.id}
+}
+
+func decodeBerth(v interface{}) (*berth, error) {
+	fields, err := codec.NewAny(v).Arr()
+	if nil != err {
+		return nil, err
+	}
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("dock record has %d fields but expects at least 5", len(fields))
+	}
+
+	b := new(berth)
+	if b.addr, err = codec.NewAny(fields[0]).String(); nil != err {
+		return nil, err
+	}
+	if b.ip, err = codec.NewAny(fields[1]).String(); nil != err {
+		return nil, err
+	}
+	if b.port, err = codec.NewAny(fields[2]).Int(); nil != err {
+		return nil, err
+	}
+
+	slots, err := codec.NewAny(fields[3]).Arr()
+	if nil != err {
+		return nil, err
+	}
+	b.slots = make([]string, len(slots))
+	for i, s := range slots {
+		slot, ok := s.(string)
+		if !ok {
+			return nil, fmt.Errorf("slot of dock record is %T but expects string", s)
+		}
+		b.slots[i] = slot
+	}
+
+	since, err := codec.NewAny(fields[4]).Int64()
+	if nil != err {
+		return nil, err
+	}
+	b.since = time.Unix(0, since)
+
+	// Name and id are missing in the snapshot of old version.
+	if len(fields) > 6 {
+		if b.name, err = codec.NewAny(fields[5]).String(); nil != err {
+			return nil, err
+		}
+		if b.id, err = codec.NewAny(fields[6]).String(); nil != err {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
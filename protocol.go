@@ -6,6 +6,7 @@ package ferry
 
 import (
 	"io"
+	"time"
 
 	"github.com/muguangyi/ferry/codec"
 )
@@ -13,15 +14,44 @@ import (
 type cProtoType uint8
 
 const (
-	cError            cProtoType = 0x0 // Error
-	cHeartbeat        cProtoType = 0x1 // Heartbeat
-	cReady            cProtoType = 0x2 // Ready
-	cRegisterRequest  cProtoType = 0x3 // Register request
-	cRegisterResponse cProtoType = 0x4 // Register response
-	cQueryRequest     cProtoType = 0x5 // Query request
-	cQueryResponse    cProtoType = 0x6 // Query response
-	cRpcRequest       cProtoType = 0x7 // RPC request
-	cRpcResponse      cProtoType = 0x8 // RPC response
+	cError            cProtoType = 0x0  // Error
+	cHeartbeat        cProtoType = 0x1  // Heartbeat
+	cReady            cProtoType = 0x2  // Ready
+	cRegisterRequest  cProtoType = 0x3  // Register request
+	cRegisterResponse cProtoType = 0x4  // Register response
+	cQueryRequest     cProtoType = 0x5  // Query request
+	cQueryResponse    cProtoType = 0x6  // Query response
+	cRpcRequest       cProtoType = 0x7  // RPC request
+	cRpcResponse      cProtoType = 0x8  // RPC response
+	cSync             cProtoType = 0x9  // Sync registry between hubs
+	cInspectRequest   cProtoType = 0xa  // Inspect request
+	cInspectResponse  cProtoType = 0xb  // Inspect response
+	cChallenge        cProtoType = 0xc  // Auth challenge
+	cAuth             cProtoType = 0xd  // Auth answer
+	cWatch            cProtoType = 0xe  // Watch slots
+	cSlotEvent        cProtoType = 0xf  // Slot event
+	cDrain            cProtoType = 0x10 // Dock draining
+	cDeregister       cProtoType = 0x11 // Deregister slots
+	cRpcNotify        cProtoType = 0x12 // RPC notify without response
+	cRpcCancel        cProtoType = 0x13 // RPC cancel
+	cStreamData       cProtoType = 0x14 // Stream data frame
+	cStreamCredit     cProtoType = 0x15 // Stream flow control credit
+	cStreamEnd        cProtoType = 0x16 // End of stream
+	cListRequest      cProtoType = 0x17 // List ready instances request
+	cListResponse     cProtoType = 0x18 // List ready instances response
+	cSubscribe        cProtoType = 0x19 // Subscribe topics
+	cUnsubscribe      cProtoType = 0x1a // Unsubscribe topics
+	cPublish          cProtoType = 0x1b // Publish message to topic
+	cPublishCredit    cProtoType = 0x1c // Publish flow control credit
+)
+
+const (
+	cSyncAdd     uint8 = 0x0 // Dock registered
+	cSyncReady   uint8 = 0x1 // Dock slots ready
+	cSyncRemove  uint8 = 0x2 // Dock removed
+	cSyncDrain   uint8 = 0x3 // Dock draining
+	cSyncCarry   uint8 = 0x4 // Dock slots added
+	cSyncUncarry uint8 = 0x5 // Dock slots removed
 )
 
 func protoMaker(id cProtoType) IProto {
@@ -44,6 +74,46 @@ func protoMaker(id cProtoType) IProto {
 		return new(protoRpcRequest)
 	case cRpcResponse:
 		return new(protoRpcResponse)
+	case cSync:
+		return new(protoSync)
+	case cInspectRequest:
+		return new(protoInspectRequest)
+	case cInspectResponse:
+		return new(protoInspectResponse)
+	case cChallenge:
+		return new(protoChallenge)
+	case cAuth:
+		return new(protoAuth)
+	case cWatch:
+		return new(protoWatch)
+	case cSlotEvent:
+		return new(protoSlotEvent)
+	case cDrain:
+		return new(protoDrain)
+	case cDeregister:
+		return new(protoDeregister)
+	case cRpcNotify:
+		return new(protoRpcNotify)
+	case cRpcCancel:
+		return new(protoRpcCancel)
+	case cStreamData:
+		return new(protoStreamData)
+	case cStreamCredit:
+		return new(protoStreamCredit)
+	case cStreamEnd:
+		return new(protoStreamEnd)
+	case cListRequest:
+		return new(protoListRequest)
+	case cListResponse:
+		return new(protoListResponse)
+	case cSubscribe:
+		return new(protoSubscribe)
+	case cUnsubscribe:
+		return new(protoUnsubscribe)
+	case cPublish:
+		return new(protoPublish)
+	case cPublishCredit:
+		return new(protoPublishCredit)
 	}
 
 	return nil
@@ -51,10 +121,16 @@ func protoMaker(id cProtoType) IProto {
 
 // Error
 type protoError struct {
+	Slot  string
 	Error string
 }
 
 func (p *protoError) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
 	return codec.NewAny(p.Error).Encode(writer)
 }
 
@@ -64,7 +140,15 @@ func (p *protoError) Unmarshal(reader io.Reader) error {
 	if nil != err {
 		return err
 	}
+	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
 
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
 	p.Error, err = any.String()
 	return err
 }
@@ -84,10 +168,22 @@ func (p *protoHeartbeat) Unmarshal(reader io.Reader) error {
 // Ready.
 type protoReady struct {
 	Slots []string
+	Name  string // Name of the dock, only told to other docks.
+	Id    string // Unique id of the dock, only told to other docks.
 }
 
 func (p *protoReady) Marshal(writer io.Writer) error {
-	return codec.NewAny(p.Slots).Encode(writer)
+	err := codec.NewAny(p.Slots).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Name).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Id).Encode(writer)
 }
 
 func (p *protoReady) Unmarshal(reader io.Reader) error {
@@ -107,16 +203,48 @@ func (p *protoReady) Unmarshal(reader io.Reader) error {
 		p.Slots[i] = iv.(string)
 	}
 
-	return nil
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Name, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Id, err = any.String()
+	return err
 }
 
 // Register request
 type protoRegisterRequest struct {
 	Slots []string
+	Addr  string // Advertised addr, empty means hub should allocate one.
+	Name  string
+	Id    string // Unique id of the dock instance.
 }
 
 func (p *protoRegisterRequest) Marshal(writer io.Writer) error {
-	return codec.NewAny(p.Slots).Encode(writer)
+	err := codec.NewAny(p.Slots).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Addr).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Name).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Id).Encode(writer)
 }
 
 func (p *protoRegisterRequest) Unmarshal(reader io.Reader) error {
@@ -136,16 +264,45 @@ func (p *protoRegisterRequest) Unmarshal(reader io.Reader) error {
 		p.Slots[i] = iv.(string)
 	}
 
-	return nil
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Addr, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Name, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Id, err = any.String()
+	return err
 }
 
 // Register response
 type protoRegisterResponse struct {
 	Port int
+	Addr string
 }
 
 func (p *protoRegisterResponse) Marshal(writer io.Writer) error {
-	return codec.NewAny(p.Port).Encode(writer)
+	err := codec.NewAny(p.Port).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Addr).Encode(writer)
 }
 
 func (p *protoRegisterResponse) Unmarshal(reader io.Reader) error {
@@ -154,18 +311,38 @@ func (p *protoRegisterResponse) Unmarshal(reader io.Reader) error {
 	if nil != err {
 		return err
 	}
-
 	p.Port, err = any.Int()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Addr, err = any.String()
 	return err
 }
 
 // Query request
 type protoQueryRequest struct {
-	Slot string
+	Slot  string
+	Dock  string // Name or id of the target dock, empty means any dock.
+	Index int64  // Index of the call querying for.
 }
 
 func (p *protoQueryRequest) Marshal(writer io.Writer) error {
-	return codec.NewAny(p.Slot).Encode(writer)
+	err := codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Dock).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Index).Encode(writer)
 }
 
 func (p *protoQueryRequest) Unmarshal(reader io.Reader) error {
@@ -174,18 +351,59 @@ func (p *protoQueryRequest) Unmarshal(reader io.Reader) error {
 	if nil != err {
 		return err
 	}
-
 	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Dock, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
 	return err
 }
 
 // Query response
 type protoQueryResponse struct {
+	Slot     string
+	Dock     string
 	DockAddr string
+	DockId   string // Id of the picked dock.
+	Index    int64  // Index of the call querying for.
 }
 
 func (p *protoQueryResponse) Marshal(writer io.Writer) error {
-	return codec.NewAny(p.DockAddr).Encode(writer)
+	err := codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Dock).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.DockAddr).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.DockId).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Index).Encode(writer)
 }
 
 func (p *protoQueryResponse) Unmarshal(reader io.Reader) error {
@@ -194,8 +412,43 @@ func (p *protoQueryResponse) Unmarshal(reader io.Reader) error {
 	if nil != err {
 		return err
 	}
+	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Dock, err = any.String()
+	if nil != err {
+		return err
+	}
 
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
 	p.DockAddr, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.DockId, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
 	return err
 }
 
@@ -206,6 +459,9 @@ type protoRpcRequest struct {
 	Method     string
 	Args       []interface{}
 	WithResult bool
+	Caller     string // slot id of the caller, empty if not called by slot.
+	Deadline   int64  // nanoseconds left before the caller gives up, 0 means no limit.
+	Stream     bool   // opens a stream served by the method.
 }
 
 func (p *protoRpcRequest) Marshal(writer io.Writer) error {
@@ -234,6 +490,21 @@ func (p *protoRpcRequest) Marshal(writer io.Writer) error {
 		return err
 	}
 
+	err = codec.NewAny(p.Caller).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Deadline).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Stream).Encode(writer)
+	if nil != err {
+		return err
+	}
+
 	return nil
 }
 
@@ -285,16 +556,46 @@ func (p *protoRpcRequest) Unmarshal(reader io.Reader) error {
 		return err
 	}
 
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Caller, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Deadline, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Stream, err = any.Bool()
+	if nil != err {
+		return err
+	}
+
 	return nil
 }
 
 // RPC response
 type protoRpcResponse struct {
-	Index  int64
-	Slot   string
-	Method string
-	Result []interface{}
-	Err    string
+	Index   int64
+	Slot    string
+	Method  string
+	Result  []interface{}
+	Err     string
+	Code    int    // Code of Err.
+	Details string // Details of Err.
+	Retry   bool   // The request is refused and could be sent to other dock.
 }
 
 func (p *protoRpcResponse) Marshal(writer io.Writer) error {
@@ -323,6 +624,21 @@ func (p *protoRpcResponse) Marshal(writer io.Writer) error {
 		return err
 	}
 
+	err = codec.NewAny(p.Code).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Details).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Retry).Encode(writer)
+	if nil != err {
+		return err
+	}
+
 	return nil
 }
 
@@ -374,5 +690,928 @@ func (p *protoRpcResponse) Unmarshal(reader io.Reader) error {
 		return err
 	}
 
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Code, err = any.Int()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Details, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Retry, err = any.Bool()
+	if nil != err {
+		return err
+	}
+
 	return nil
 }
+
+// Sync
+type protoSync struct {
+	Op     uint8
+	Origin string
+	Addr   string
+	Slots  []string
+	Since  int64
+	Name   string
+	Id     string
+}
+
+func (p *protoSync) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Op).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Origin).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Addr).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Slots).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Since).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Name).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Id).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return nil
+}
+
+func (p *protoSync) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Op, err = any.Uint8()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Origin, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Addr, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Slots = make([]string, len(arr))
+	for i, iv := range arr {
+		p.Slots[i] = iv.(string)
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Since, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Name, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Id, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	return nil
+}
+
+// Inspect request
+type protoInspectRequest struct {
+}
+
+func (p *protoInspectRequest) Marshal(writer io.Writer) error {
+	return nil
+}
+
+func (p *protoInspectRequest) Unmarshal(reader io.Reader) error {
+	return nil
+}
+
+// Inspect response
+type protoInspectResponse struct {
+	Docks []DockInfo
+}
+
+func (p *protoInspectResponse) Marshal(writer io.Writer) error {
+	docks := make([]interface{}, len(p.Docks))
+	for i, d := range p.Docks {
+		ids := make([]interface{}, len(d.Slots))
+		readies := make([]interface{}, len(d.Slots))
+		for j, s := range d.Slots {
+			ids[j] = s.ID
+			readies[j] = s.Ready
+		}
+		docks[i] = []interface{}{d.Addr, d.Hub, d.Since.UnixNano(), ids, readies, d.Name, d.ID}
+	}
+
+	return codec.NewAny(docks).Encode(writer)
+}
+
+func (p *protoInspectResponse) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Docks = make([]DockInfo, len(arr))
+	for i, iv := range arr {
+		fields := iv.([]interface{})
+		since, err := codec.NewAny(fields[2]).Int64()
+		if nil != err {
+			return err
+		}
+		ids := fields[3].([]interface{})
+		readies := fields[4].([]interface{})
+
+		d := DockInfo{
+			Addr:  fields[0].(string),
+			Hub:   fields[1].(string),
+			Since: time.Unix(0, since),
+			Slots: make([]SlotInfo, len(ids)),
+			Name:  fields[5].(string),
+			ID:    fields[6].(string),
+		}
+		for j := range ids {
+			d.Slots[j] = SlotInfo{ID: ids[j].(string), Ready: readies[j].(bool)}
+		}
+		p.Docks[i] = d
+	}
+
+	return nil
+}
+
+// Challenge
+type protoChallenge struct {
+	Nonce string
+}
+
+func (p *protoChallenge) Marshal(writer io.Writer) error {
+	return codec.NewAny(p.Nonce).Encode(writer)
+}
+
+func (p *protoChallenge) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	p.Nonce, err = any.String()
+	return err
+}
+
+// Auth
+type protoAuth struct {
+	Name string
+	Mac  string
+}
+
+func (p *protoAuth) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Name).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Mac).Encode(writer)
+}
+
+func (p *protoAuth) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Name, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Mac, err = any.String()
+	return err
+}
+
+// Watch
+type protoWatch struct {
+	Slots []string
+}
+
+func (p *protoWatch) Marshal(writer io.Writer) error {
+	return codec.NewAny(p.Slots).Encode(writer)
+}
+
+func (p *protoWatch) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Slots = make([]string, len(arr))
+	for i, iv := range arr {
+		p.Slots[i] = iv.(string)
+	}
+
+	return nil
+}
+
+// Slot event
+type protoSlotEvent struct {
+	Op   uint8
+	Slot string
+	Addr string
+}
+
+func (p *protoSlotEvent) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Op).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Addr).Encode(writer)
+}
+
+func (p *protoSlotEvent) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Op, err = any.Uint8()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Addr, err = any.String()
+	return err
+}
+
+// Drain
+type protoDrain struct {
+}
+
+func (p *protoDrain) Marshal(writer io.Writer) error {
+	return nil
+}
+
+func (p *protoDrain) Unmarshal(reader io.Reader) error {
+	return nil
+}
+
+// Deregister
+type protoDeregister struct {
+	Slots []string
+	Addr  string
+}
+
+func (p *protoDeregister) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Slots).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Addr).Encode(writer)
+}
+
+func (p *protoDeregister) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Slots = make([]string, len(arr))
+	for i, iv := range arr {
+		p.Slots[i] = iv.(string)
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Addr, err = any.String()
+	return err
+}
+
+// RPC notify
+type protoRpcNotify struct {
+	Slot   string
+	Method string
+	Args   []interface{}
+	Caller string
+}
+
+func (p *protoRpcNotify) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Method).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Args).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Caller).Encode(writer)
+}
+
+func (p *protoRpcNotify) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Method, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Args, err = any.Arr()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Caller, err = any.String()
+	return err
+}
+
+// RPC cancel
+type protoRpcCancel struct {
+	Index int64
+}
+
+func (p *protoRpcCancel) Marshal(writer io.Writer) error {
+	return codec.NewAny(p.Index).Encode(writer)
+}
+
+func (p *protoRpcCancel) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	p.Index, err = any.Int64()
+	return err
+}
+
+// Stream data
+type protoStreamData struct {
+	Index  int64
+	Callee bool // sent by the callee side.
+	Value  interface{}
+}
+
+func (p *protoStreamData) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Index).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Callee).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Value).Encode(writer)
+}
+
+func (p *protoStreamData) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Callee, err = any.Bool()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Value = any.Any()
+	return nil
+}
+
+// Stream credit
+type protoStreamCredit struct {
+	Index  int64
+	Callee bool // sent by the callee side.
+	Credit int
+}
+
+func (p *protoStreamCredit) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Index).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Callee).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Credit).Encode(writer)
+}
+
+func (p *protoStreamCredit) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Callee, err = any.Bool()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Credit, err = any.Int()
+	return err
+}
+
+// Stream end
+type protoStreamEnd struct {
+	Index   int64
+	Callee  bool // sent by the callee side.
+	Err     string
+	Code    int    // Code of Err.
+	Details string // Details of Err.
+}
+
+func (p *protoStreamEnd) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Index).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Callee).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Err).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Code).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Details).Encode(writer)
+}
+
+func (p *protoStreamEnd) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Callee, err = any.Bool()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Err, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Code, err = any.Int()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Details, err = any.String()
+	return err
+}
+
+// List request
+type protoListRequest struct {
+	Index int64
+	Slot  string
+}
+
+func (p *protoListRequest) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Index).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Slot).Encode(writer)
+}
+
+func (p *protoListRequest) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Slot, err = any.String()
+	return err
+}
+
+// List response
+type protoListResponse struct {
+	Index int64
+	Slot  string
+	Ids   []string
+	Names []string
+}
+
+func (p *protoListResponse) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Index).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Slot).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Ids).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Names).Encode(writer)
+}
+
+func (p *protoListResponse) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Index, err = any.Int64()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Slot, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	ids, err := any.Arr()
+	if nil != err {
+		return err
+	}
+	p.Ids = make([]string, len(ids))
+	for i, iv := range ids {
+		p.Ids[i] = iv.(string)
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	names, err := any.Arr()
+	if nil != err {
+		return err
+	}
+	p.Names = make([]string, len(names))
+	for i, iv := range names {
+		p.Names[i] = iv.(string)
+	}
+
+	return nil
+}
+
+// Subscribe
+type protoSubscribe struct {
+	Topics []string
+}
+
+func (p *protoSubscribe) Marshal(writer io.Writer) error {
+	return codec.NewAny(p.Topics).Encode(writer)
+}
+
+func (p *protoSubscribe) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Topics = make([]string, len(arr))
+	for i, iv := range arr {
+		p.Topics[i] = iv.(string)
+	}
+
+	return nil
+}
+
+// Unsubscribe
+type protoUnsubscribe struct {
+	Topics []string
+}
+
+func (p *protoUnsubscribe) Marshal(writer io.Writer) error {
+	return codec.NewAny(p.Topics).Encode(writer)
+}
+
+func (p *protoUnsubscribe) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+
+	arr, err := any.Arr()
+	if nil != err {
+		return err
+	}
+
+	p.Topics = make([]string, len(arr))
+	for i, iv := range arr {
+		p.Topics[i] = iv.(string)
+	}
+
+	return nil
+}
+
+// Publish
+type protoPublish struct {
+	Topic   string
+	Msg     interface{}
+	Relayed bool // relayed from other hub.
+}
+
+func (p *protoPublish) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Topic).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	err = codec.NewAny(p.Msg).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Relayed).Encode(writer)
+}
+
+func (p *protoPublish) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Topic, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Msg = any.Any()
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Relayed, err = any.Bool()
+	return err
+}
+
+// Publish credit
+type protoPublishCredit struct {
+	Topic  string
+	Credit int
+}
+
+func (p *protoPublishCredit) Marshal(writer io.Writer) error {
+	err := codec.NewAny(p.Topic).Encode(writer)
+	if nil != err {
+		return err
+	}
+
+	return codec.NewAny(p.Credit).Encode(writer)
+}
+
+func (p *protoPublishCredit) Unmarshal(reader io.Reader) error {
+	any := codec.NewAny(nil)
+
+	err := any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Topic, err = any.String()
+	if nil != err {
+		return err
+	}
+
+	err = any.Decode(reader)
+	if nil != err {
+		return err
+	}
+	p.Credit, err = any.Int()
+	return err
+}
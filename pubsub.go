@@ -0,0 +1,513 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/muguangyi/ferry/chancall"
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cTopicBuffer int = 256
+	cTopicWindow int = 64
+)
+
+// subscription delivers the messages of topic to method of slot in order.
+type subscription struct {
+	slot   *slot
+	topic  string
+	method string
+	queue  chan interface{}
+	done   chan struct{} // closed when subscription is dropped.
+}
+
+func (s *subscription) run() {
+	caller := chancall.NewCaller(s.slot.callee)
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := caller.Call(s.method, msg); nil != err {
+				log.Printf("[%s] deliver message of topic [%s] failed: %s", s.slot.callee.Name(), s.topic, err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// feed hands the messages of topic from hub to all subscriptions in this
+// dock. Hub sends at most cTopicWindow messages not handed over, and the feed
+// grants credits back after handing them over.
+type feed struct {
+	topic    string
+	subs     []*subscription // guarded by topicsMutex of dock.
+	inbox    chan interface{}
+	consumed int
+	done     chan struct{} // closed when the last subscription is dropped.
+}
+
+func (f *feed) run(d *dock) {
+	for {
+		select {
+		case msg := <-f.inbox:
+			d.topicsMutex.Lock()
+			subs := f.subs
+			d.topicsMutex.Unlock()
+
+			// Wait for the subscriber which doesn't keep up, so that hub
+			// and publisher are slowed down too.
+			for _, sub := range subs {
+				select {
+				case sub.queue <- msg:
+				case <-sub.done:
+				case <-f.done:
+					return
+				}
+			}
+			f.consume(d)
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// consume grants credits to hub when half of the window is consumed.
+func (f *feed) consume(d *dock) {
+	f.consumed++
+	if f.consumed < cTopicWindow/2 {
+		return
+	}
+
+	if hub := d.currentHub(); nil != hub {
+		hub.Push(&packer{
+			Id: cPublishCredit,
+			P:  &protoPublishCredit{Topic: f.topic, Credit: f.consumed},
+		})
+	}
+	f.consumed = 0
+}
+
+// subscribe delivers the messages of topic to method of slot, and tells hub
+// if it's the first subscription of topic in this dock.
+func (d *dock) subscribe(s *slot, topic string, method string) error {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	if nil == d.topics {
+		return fmt.Errorf("[%s] dock is closed!", s.callee.Name())
+	}
+	f, ok := d.topics[topic]
+	if ok {
+		for _, sub := range f.subs {
+			if sub.slot == s {
+				return fmt.Errorf("[%s] topic [%s] is subscribed already!", s.callee.Name(), topic)
+			}
+		}
+	} else {
+		f = &feed{topic: topic, inbox: make(chan interface{}, cTopicWindow), done: make(chan struct{})}
+		d.topics[topic] = f
+		go f.run(d)
+
+		if hub := d.currentHub(); nil != hub {
+			hub.Send(&packer{
+				Id: cSubscribe,
+				P:  &protoSubscribe{Topics: []string{topic}},
+			})
+		}
+	}
+
+	sub := &subscription{slot: s, topic: topic, method: method, queue: make(chan interface{}, cTopicBuffer), done: make(chan struct{})}
+	go sub.run()
+	f.subs = append(f.subs, sub)
+
+	return nil
+}
+
+// unsubscribe stops delivering the messages of topic to slot, and tells hub
+// if it's the last subscription of topic in this dock.
+func (d *dock) unsubscribe(s *slot, topic string) error {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	if !d.cut(s, topic) {
+		return fmt.Errorf("[%s] topic [%s] is not subscribed!", s.callee.Name(), topic)
+	}
+
+	return nil
+}
+
+// mute drops all subscriptions of slot.
+func (d *dock) mute(s *slot) {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	for topic := range d.topics {
+		d.cut(s, topic)
+	}
+}
+
+// cut drops the subscription of topic of slot. It must be called with
+// topicsMutex locked.
+func (d *dock) cut(s *slot, topic string) bool {
+	f, ok := d.topics[topic]
+	if !ok {
+		return false
+	}
+
+	for i, sub := range f.subs {
+		if sub.slot != s {
+			continue
+		}
+
+		close(sub.done)
+		f.subs = append(f.subs[:i:i], f.subs[i+1:]...)
+		if len(f.subs) > 0 {
+			return true
+		}
+
+		close(f.done)
+		delete(d.topics, topic)
+		if hub := d.currentHub(); nil != hub {
+			hub.Send(&packer{
+				Id: cUnsubscribe,
+				P:  &protoUnsubscribe{Topics: []string{topic}},
+			})
+		}
+		return true
+	}
+
+	return false
+}
+
+// publish sends msg to all subscribers of topic through hub, and waits if
+// there is no credit granted by hub.
+func (d *dock) publish(topic string, msg interface{}) error {
+	hub := d.currentHub()
+	if nil == hub {
+		return newError(CodeUnavailable, "[%s] hub is not connected!", topic)
+	}
+
+	select {
+	case <-d.quota(topic):
+	case <-d.closeSig:
+		return newError(CodeUnavailable, "[%s] dock is closed!", topic)
+	}
+
+	err := hub.Push(&packer{
+		Id: cPublish,
+		P:  &protoPublish{Topic: topic, Msg: msg},
+	})
+	if nil != err {
+		return newError(CodeUnavailable, "[%s] publish failed: %s", topic, err)
+	}
+
+	return nil
+}
+
+// quota returns the credits to publish topic, which is full at first.
+func (d *dock) quota(topic string) chan struct{} {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	q, ok := d.quotas[topic]
+	if !ok {
+		q = make(chan struct{}, cTopicWindow)
+		refill(q, cTopicWindow)
+		d.quotas[topic] = q
+	}
+
+	return q
+}
+
+// credited adds the credits granted by hub for publishing.
+func (d *dock) credited(p *protoPublishCredit) {
+	refill(d.quota(p.Topic), p.Credit)
+}
+
+// deliver queues the message from hub to the feed of its topic without
+// waiting, as hub never sends more than the credits.
+func (d *dock) deliver(req *protoPublish) {
+	d.topicsMutex.Lock()
+	f, ok := d.topics[req.Topic]
+	d.topicsMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case f.inbox <- req.Msg:
+	default:
+		log.Printf("[%s] message of topic dropped as hub exceeds credits.", req.Topic)
+	}
+}
+
+// resubscribe tells hub all subscribed topics again after registered, and
+// the credits to publish are full for the new hub.
+func (d *dock) resubscribe(hub network.IPeer) {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	for _, q := range d.quotas {
+		refill(q, cTopicWindow)
+	}
+
+	if 0 == len(d.topics) {
+		return
+	}
+
+	topics := make([]string, 0, len(d.topics))
+	for topic := range d.topics {
+		topics = append(topics, topic)
+	}
+	hub.Send(&packer{
+		Id: cSubscribe,
+		P:  &protoSubscribe{Topics: topics},
+	})
+}
+
+// hush stops all subscriptions.
+func (d *dock) hush() {
+	d.topicsMutex.Lock()
+	defer d.topicsMutex.Unlock()
+
+	for _, f := range d.topics {
+		for _, sub := range f.subs {
+			close(sub.done)
+		}
+		close(f.done)
+	}
+	d.topics = nil
+}
+
+// refill adds n credits to q, and the ones over its capacity are ignored.
+func refill(q chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case q <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// join adds peer as subscriber of topics.
+func (h *hub) join(peer network.IPeer, topics []string) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	for _, topic := range topics {
+		outlets, ok := h.topics[topic]
+		if !ok {
+			outlets = make(map[network.IPeer]*outlet)
+			h.topics[topic] = outlets
+		}
+		if _, ok := outlets[peer]; !ok {
+			outlets[peer] = newOutlet(topic, false, peer.Push)
+		}
+	}
+}
+
+// leave removes peer from subscribers of topics, and all topics if nil.
+func (h *hub) leave(peer network.IPeer, topics []string) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	if nil == topics {
+		for topic := range h.topics {
+			topics = append(topics, topic)
+		}
+	}
+
+	for _, topic := range topics {
+		if outlets, ok := h.topics[topic]; ok {
+			if o, ok := outlets[peer]; ok {
+				o.stop()
+				delete(outlets, peer)
+			}
+			if 0 == len(outlets) {
+				delete(h.topics, topic)
+			}
+		}
+	}
+}
+
+// credited adds the credits granted by the subscriber at peer.
+func (h *hub) credited(peer network.IPeer, p *protoPublishCredit) {
+	h.docksMutex.Lock()
+	o, ok := h.topics[p.Topic][peer]
+	h.docksMutex.Unlock()
+
+	if ok {
+		o.grant(p.Credit)
+	}
+}
+
+// publish forwards the message from peer to all subscribers of its topic,
+// and relays it to peer hubs if it's from dock. The message is queued to the
+// outlet of each subscriber without waiting, and peer is granted a credit
+// when all outlets forward it, so that the publisher is slowed down by the
+// subscribers which don't keep up.
+func (h *hub) publish(peer network.IPeer, req *protoPublish) {
+	h.docksMutex.Lock()
+	outlets := make([]*outlet, 0, len(h.topics[req.Topic])+len(h.links))
+	for _, o := range h.topics[req.Topic] {
+		outlets = append(outlets, o)
+	}
+	h.docksMutex.Unlock()
+
+	if !req.Relayed {
+		for _, l := range h.links {
+			outlets = append(outlets, l.outlet(req.Topic))
+		}
+	}
+
+	p := &posting{
+		req:  req,
+		left: int32(len(outlets)),
+		grant: func() {
+			peer.Push(&packer{
+				Id: cPublishCredit,
+				P:  &protoPublishCredit{Topic: req.Topic, Credit: 1},
+			})
+		},
+	}
+	if 0 == len(outlets) {
+		go p.grant()
+		return
+	}
+
+	for _, o := range outlets {
+		o.post(p)
+	}
+}
+
+// posting is a message published, and the publisher is granted a credit
+// when it's forwarded by all outlets.
+type posting struct {
+	req   *protoPublish
+	left  int32 // outlets not forwarded yet.
+	grant func()
+}
+
+func (p *posting) ack() {
+	if 0 == atomic.AddInt32(&p.left, -1) {
+		p.grant()
+	}
+}
+
+func newOutlet(topic string, relay bool, push func(obj interface{}) error) *outlet {
+	o := &outlet{
+		topic:   topic,
+		relay:   relay,
+		push:    push,
+		credits: make(chan struct{}, cTopicWindow),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	refill(o.credits, cTopicWindow)
+	go o.run()
+
+	return o
+}
+
+// outlet forwards the messages of topic to a subscriber dock or peer hub in
+// order, and at most cTopicWindow messages not granted back by it.
+type outlet struct {
+	sync.Mutex
+	topic   string
+	relay   bool // forwards to peer hub.
+	push    func(obj interface{}) error
+	credits chan struct{}
+	pending []*posting
+	stopped bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// post queues the message without waiting, and the message is acked at once
+// if the outlet is stopped.
+func (o *outlet) post(p *posting) {
+	o.Lock()
+	if o.stopped {
+		o.Unlock()
+		p.ack()
+		return
+	}
+	o.pending = append(o.pending, p)
+	o.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outlet) run() {
+	defer o.flush()
+
+	for {
+		o.Lock()
+		n := len(o.pending)
+		var p *posting
+		if n > 0 {
+			p = o.pending[0]
+			o.pending = o.pending[1:]
+		}
+		o.Unlock()
+
+		if 0 == n {
+			select {
+			case <-o.wake:
+				continue
+			case <-o.done:
+				return
+			}
+		}
+
+		select {
+		case <-o.credits:
+		case <-o.done:
+			p.ack()
+			return
+		}
+
+		req := p.req
+		if o.relay {
+			req = &protoPublish{Topic: req.Topic, Msg: req.Msg, Relayed: true}
+		}
+		if err := o.push(&packer{Id: cPublish, P: req}); nil != err {
+			log.Printf("[%s] message of topic dropped: %s", o.topic, err)
+		}
+		p.ack()
+	}
+}
+
+// flush acks the messages not forwarded, as the outlet is stopped.
+func (o *outlet) flush() {
+	o.Lock()
+	pending := o.pending
+	o.pending = nil
+	o.stopped = true
+	o.Unlock()
+
+	for _, p := range pending {
+		p.ack()
+	}
+}
+
+// grant adds the credits granted by the subscriber.
+func (o *outlet) grant(n int) {
+	refill(o.credits, n)
+}
+
+// stop quits forwarding, and it must be called once.
+func (o *outlet) stop() {
+	close(o.done)
+}
@@ -5,17 +5,35 @@
 package ferry
 
 import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cMaxRetries int = 3
 )
 
+var rpcIndex int64 = time.Now().UnixNano()
+
 func newRpc() *rpc {
-	return &rpc{index: time.Now().UnixNano(), req: nil, ret: make(chan *ret, 1)}
+	return &rpc{index: atomic.AddInt64(&rpcIndex, 1), req: nil, ret: make(chan *ret, 1)}
 }
 
 type rpc struct {
-	index int64
-	req   *protoRpcRequest
-	ret   chan *ret
+	index    int64
+	req      *protoRpcRequest
+	dock     string        // name or id of the target dock, empty means any dock.
+	via      string        // id of the dock picked by hub if targeting any dock.
+	querying bool          // waiting for the query result from hub.
+	peer     network.IPeer // peer which the request has been sent to.
+	retries  int           // times refused by draining docks.
+	oneway   bool          // no response is expected.
+	ret      chan *ret
 }
 
 type ret struct {
@@ -23,40 +41,175 @@ type ret struct {
 	err    error
 }
 
-func (r *rpc) call(dock *dock, name string, method string, args ...interface{}) error {
+func (r *rpc) call(ctx context.Context, dock *dock, caller string, name string, method string, args ...interface{}) error {
+	slot, target := splitTarget(name)
+	r.dock = target
 	r.req = &protoRpcRequest{
 		Index:      r.index,
-		Slot:       name,
+		Slot:       slot,
 		Method:     method,
 		Args:       args,
 		WithResult: false,
+		Caller:     caller,
+		Deadline:   deadline(ctx, seconds(dock.conf.CallTimeout)),
 	}
 
 	dock.commit(r)
 
-	ret := <-r.ret
-	close(r.ret)
-
+	ret := r.wait(ctx, dock)
 	return ret.err
 }
 
-func (r *rpc) callWithResult(dock *dock, name string, method string, args ...interface{}) ([]interface{}, error) {
+func (r *rpc) callWithResult(ctx context.Context, dock *dock, caller string, name string, method string, args ...interface{}) ([]interface{}, error) {
+	slot, target := splitTarget(name)
+	r.dock = target
 	r.req = &protoRpcRequest{
 		Index:      r.index,
-		Slot:       name,
+		Slot:       slot,
 		Method:     method,
 		Args:       args,
 		WithResult: true,
+		Caller:     caller,
+		Deadline:   deadline(ctx, seconds(dock.conf.CallTimeout)),
 	}
 
 	dock.commit(r)
 
-	ret := <-r.ret
-	close(r.ret)
-
+	ret := r.wait(ctx, dock)
 	return ret.result, ret.err
 }
 
+// open sends the request to open a stream, and returns the peer accepting it.
+func (r *rpc) open(ctx context.Context, dock *dock, caller string, name string, method string, args ...interface{}) (network.IPeer, error) {
+	slot, target := splitTarget(name)
+	r.dock = target
+	r.req = &protoRpcRequest{
+		Index:    r.index,
+		Slot:     slot,
+		Method:   method,
+		Args:     args,
+		Caller:   caller,
+		Deadline: deadline(ctx, 0),
+		Stream:   true,
+	}
+
+	dock.commit(r)
+
+	ret := r.wait(ctx, dock)
+	return r.peer, ret.err
+}
+
+// notify sends the request without waiting, and it's dropped if the target
+// slot isn't found before CallTimeout.
+func (r *rpc) notify(dock *dock, caller string, name string, method string, args ...interface{}) {
+	slot, target := splitTarget(name)
+	r.dock = target
+	r.oneway = true
+	r.req = &protoRpcRequest{
+		Index:  r.index,
+		Slot:   slot,
+		Method: method,
+		Args:   args,
+		Caller: caller,
+	}
+
+	dock.commit(r)
+
+	time.AfterFunc(seconds(dock.conf.CallTimeout), func() {
+		if dock.cancel(r, nil) {
+			log.Printf("[%s] notify dropped as slot not found!", r.key())
+		}
+	})
+}
+
+// wait returns the response, or error if not responded before CallTimeout or
+// ctx is done.
+func (r *rpc) wait(ctx context.Context, dock *dock) *ret {
+	timer := time.NewTimer(seconds(dock.conf.CallTimeout))
+	defer timer.Stop()
+
+	var resp *ret
+	select {
+	case resp = <-r.ret:
+	case <-timer.C:
+		dock.cancel(r, newError(CodeTimeout, "[%s] function call timeout!", r.req.Method))
+		resp = <-r.ret
+	case <-ctx.Done():
+		// The response may arrive before canceled.
+		dock.cancel(r, ctx.Err())
+		resp = <-r.ret
+	}
+
+	if nil != resp.err {
+		resp.err = expire(ctx, resp.err)
+	}
+	return resp
+}
+
 func (r *rpc) callback(ret *ret) {
 	r.ret <- ret
 }
+
+// deadline returns the time left before ctx deadline, and timeout if it's
+// shorter. Zero timeout means no limit.
+func deadline(ctx context.Context, timeout time.Duration) int64 {
+	if d, ok := ctx.Deadline(); ok {
+		if left := time.Until(d); 0 == timeout || left < timeout {
+			timeout = left
+			if timeout <= 0 {
+				timeout = 1
+			}
+		}
+	}
+
+	return int64(timeout)
+}
+
+// expire returns the error of ctx instead of err if ctx is done or its
+// deadline passes, as the remote call fails for the same reason.
+func expire(ctx context.Context, err error) error {
+	if nil != ctx.Err() {
+		return ctx.Err()
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+// key returns the target of the rpc to find the remote dock.
+func (r *rpc) key() string {
+	return joinTarget(r.req.Slot, r.dock)
+}
+
+// route returns the target to send the request to. The rpc targeting any dock
+// goes to the dock picked by hub for it, and route is empty before picked.
+func (r *rpc) route() string {
+	if "" != r.dock {
+		return r.key()
+	}
+	if "" != r.via {
+		return joinTarget(r.req.Slot, r.via)
+	}
+
+	return ""
+}
+
+// splitTarget parses the call target like "IRoom@room-3" into slot id and the
+// name or id of the dock.
+func splitTarget(target string) (string, string) {
+	if i := strings.Index(target, "@"); i >= 0 {
+		return target[:i], target[i+1:]
+	}
+
+	return target, ""
+}
+
+func joinTarget(slot string, dock string) string {
+	if "" == dock {
+		return slot
+	}
+
+	return slot + "@" + dock
+}
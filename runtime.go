@@ -8,16 +8,22 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 )
 
 type instance interface {
 	Close()
 }
 
-var insts []instance = make([]instance, 0)
+var (
+	instsMutex sync.Mutex
+	insts      []instance = make([]instance, 0)
+)
 
 func wait(inst instance) {
+	instsMutex.Lock()
 	insts = append(insts, inst)
+	instsMutex.Unlock()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
@@ -27,8 +33,12 @@ func wait(inst instance) {
 }
 
 func destroy() {
-	for i := len(insts) - 1; i >= 0; i-- {
-		insts[i].Close()
+	instsMutex.Lock()
+	closing := insts
+	insts = make([]instance, 0)
+	instsMutex.Unlock()
+
+	for i := len(closing) - 1; i >= 0; i-- {
+		closing[i].Close()
 	}
-	insts = insts[:0]
 }
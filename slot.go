@@ -5,9 +5,12 @@
 package ferry
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/muguangyi/ferry/chancall"
 )
@@ -34,8 +37,13 @@ type slot struct {
 	callee       chancall.ICallee
 	dock         *dock
 	visiters     map[string]interface{}
+	aclMutex     sync.Mutex
+	acl          map[string][]string // callers allowed by method.
 	closeSig     chan bool
 	wg           sync.WaitGroup
+	servingMutex sync.Mutex
+	serving      sync.WaitGroup // calls in progress.
+	leaving      bool
 }
 
 func (s *slot) Visit(name string) interface{} {
@@ -51,11 +59,69 @@ func (s *slot) Visit(name string) interface{} {
 }
 
 func (s *slot) Call(name string, method string, args ...interface{}) error {
-	return s.dock.call(name, method, args...)
+	return s.dock.call(context.Background(), s.callee.Name(), name, method, args...)
 }
 
 func (s *slot) CallWithResult(name string, method string, args ...interface{}) ([]interface{}, error) {
-	return s.dock.callWithResult(name, method, args...)
+	return s.dock.callWithResult(context.Background(), s.callee.Name(), name, method, args...)
+}
+
+func (s *slot) CallContext(ctx context.Context, name string, method string, args ...interface{}) error {
+	return s.dock.call(ctx, s.callee.Name(), name, method, args...)
+}
+
+func (s *slot) CallWithResultContext(ctx context.Context, name string, method string, args ...interface{}) ([]interface{}, error) {
+	return s.dock.callWithResult(ctx, s.callee.Name(), name, method, args...)
+}
+
+func (s *slot) Broadcast(ctx context.Context, id string, method string, gather Gather, args ...interface{}) ([]Reply, error) {
+	return s.dock.broadcast(ctx, s.callee.Name(), id, method, gather, args...)
+}
+
+func (s *slot) Subscribe(topic string, method string) error {
+	return s.dock.subscribe(s, topic, method)
+}
+
+func (s *slot) Unsubscribe(topic string) error {
+	return s.dock.unsubscribe(s, topic)
+}
+
+func (s *slot) Publish(topic string, msg interface{}) error {
+	return s.dock.publish(topic, msg)
+}
+
+func (s *slot) Stream(ctx context.Context, name string, method string, args ...interface{}) (IStream, error) {
+	return s.dock.stream(ctx, s.callee.Name(), name, method, args...)
+}
+
+func (s *slot) Notify(name string, method string, args ...interface{}) {
+	s.dock.notify(s.callee.Name(), name, method, args...)
+}
+
+func (s *slot) Go(name string, method string, args ...interface{}) IFuture {
+	return goCall(func() ([]interface{}, error) {
+		return s.dock.callWithResult(context.Background(), s.callee.Name(), name, method, args...)
+	})
+}
+
+func (s *slot) Carry(target ISlot) error {
+	return s.dock.carry(target.(*slot))
+}
+
+func (s *slot) Uncarry(id string) error {
+	return s.dock.uncarry(id)
+}
+
+func (s *slot) Watch(id string) <-chan SlotEvent {
+	return s.dock.watch(s, id)
+}
+
+func (s *slot) Unwatch(ch <-chan SlotEvent) {
+	s.dock.unwatch(ch)
+}
+
+func (s *slot) Allow(method string, callers ...string) {
+	s.allow(method, callers...)
 }
 
 func (s *slot) SetTimeout(method string, timeout float32) {
@@ -70,3 +136,42 @@ func run(s *slot) {
 const (
 	cDefaultTimeout float32 = 1.0
 )
+
+// enter counts a call to the slot in progress, and returns false if the slot
+// is uncarrying.
+func (s *slot) enter() bool {
+	s.servingMutex.Lock()
+	defer s.servingMutex.Unlock()
+
+	if s.leaving {
+		return false
+	}
+	s.serving.Add(1)
+
+	return true
+}
+
+// exit marks a call entered done.
+func (s *slot) exit() {
+	s.serving.Done()
+}
+
+// leave refuses new calls to the slot, and waits for the calls in progress
+// until timeout.
+func (s *slot) leave(timeout float32) {
+	s.servingMutex.Lock()
+	s.leaving = true
+	s.servingMutex.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(seconds(timeout)):
+		log.Printf("[%s] slot drain timeout, calls in progress are dropped.", s.callee.Name())
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/muguangyi/ferry/chancall"
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cStreamWindow int = 16
+)
+
+func newStream(ctx context.Context, index int64, callee bool) *stream {
+	// The method serving the stream gives up its slot while waiting.
+	if callee {
+		ctx = chancall.Yieldable(ctx)
+	}
+
+	s := &stream{
+		index:   index,
+		callee:  callee,
+		credits: make(chan struct{}, cStreamWindow),
+		frames:  make(chan interface{}, cStreamWindow),
+		ended:   make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for i := 0; i < cStreamWindow; i++ {
+		s.credits <- struct{}{}
+	}
+
+	return s
+}
+
+// stream is one side of a stream call. Each side sends at most cStreamWindow
+// frames the other side hasn't consumed, and the other side grants credits
+// back after consuming.
+type stream struct {
+	sync.Mutex
+	index    int64
+	callee   bool          // this side is the callee.
+	peer     network.IPeer // the other side, nil if in the same dock.
+	remote   func(pack *packer)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	credits  chan struct{}    // frames allowed to send.
+	frames   chan interface{} // frames received.
+	consumed int              // frames consumed since last credit.
+	closed   bool             // end is sent.
+	endOnce  sync.Once
+	ended    chan struct{} // closed when the other side ends.
+	err      error         // io.EOF or the error the other side ends with.
+}
+
+func (s *stream) Send(v interface{}) (err error) {
+	select {
+	case <-s.credits:
+	default:
+		chancall.Block(s.ctx, func() {
+			err = s.credit()
+		})
+		if nil != err {
+			return err
+		}
+	}
+
+	s.Lock()
+	closed := s.closed
+	s.Unlock()
+	if closed {
+		return fmt.Errorf("[%d] stream is closed!", s.index)
+	}
+
+	s.remote(&packer{
+		Id: cStreamData,
+		P:  &protoStreamData{Index: s.index, Callee: s.callee, Value: v},
+	})
+
+	return nil
+}
+
+func (s *stream) Recv() (v interface{}, err error) {
+	select {
+	case v := <-s.frames:
+		return s.consume(v), nil
+	default:
+	}
+
+	chancall.Block(s.ctx, func() {
+		v, err = s.next()
+	})
+	return
+}
+
+func (s *stream) CloseSend() error {
+	s.end(nil)
+	return nil
+}
+
+func (s *stream) Close() error {
+	s.end(nil)
+	s.cancel()
+	return nil
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+// credit waits until allowed to send a frame.
+func (s *stream) credit() error {
+	// The caller side is over once callee ends, but the callee side could
+	// still send after caller closes send.
+	var over chan struct{}
+	if !s.callee {
+		over = s.ended
+	}
+
+	select {
+	case <-s.credits:
+		return nil
+	case <-over:
+		return fmt.Errorf("[%d] stream is ended!", s.index)
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// next waits for the frame from the other side.
+func (s *stream) next() (interface{}, error) {
+	select {
+	case v := <-s.frames:
+		return s.consume(v), nil
+	case <-s.ended:
+		return s.rest()
+	case <-s.ctx.Done():
+		select {
+		case <-s.ended:
+			return s.rest()
+		default:
+			return nil, s.ctx.Err()
+		}
+	}
+}
+
+// rest returns the frame left after the other side ended, or the error it
+// ended with.
+func (s *stream) rest() (interface{}, error) {
+	select {
+	case v := <-s.frames:
+		return s.consume(v), nil
+	default:
+		return nil, s.err
+	}
+}
+
+// consume grants credits to the other side when half of the window is
+// consumed.
+func (s *stream) consume(v interface{}) interface{} {
+	s.Lock()
+	s.consumed++
+	credit := 0
+	if s.consumed >= cStreamWindow/2 {
+		credit = s.consumed
+		s.consumed = 0
+	}
+	s.Unlock()
+
+	if credit > 0 {
+		s.remote(&packer{
+			Id: cStreamCredit,
+			P:  &protoStreamCredit{Index: s.index, Callee: s.callee, Credit: credit},
+		})
+	}
+
+	return v
+}
+
+// end tells the other side no more frames with err, and only the first one
+// is sent.
+func (s *stream) end(err error) {
+	s.Lock()
+	closed := s.closed
+	s.closed = true
+	s.Unlock()
+	if closed {
+		return
+	}
+
+	end := &protoStreamEnd{Index: s.index, Callee: s.callee}
+	if nil != err {
+		end.Err, end.Code, end.Details = flatten(err)
+	}
+	s.remote(&packer{
+		Id: cStreamEnd,
+		P:  end,
+	})
+}
+
+// handle receives the frame from the other side.
+func (s *stream) handle(pack *packer) {
+	switch p := pack.P.(type) {
+	case *protoStreamData:
+		select {
+		case s.frames <- p.Value:
+		default:
+			log.Printf("[%d] stream frame dropped as other side exceeds credits.", s.index)
+		}
+	case *protoStreamCredit:
+		for i := 0; i < p.Credit; i++ {
+			select {
+			case s.credits <- struct{}{}:
+			default:
+			}
+		}
+	case *protoStreamEnd:
+		s.finish(rebuild(p.Err, p.Code, p.Details))
+	}
+}
+
+// finish marks the other side ended, and nil reason means io.EOF.
+func (s *stream) finish(reason error) {
+	s.endOnce.Do(func() {
+		if nil == reason {
+			s.err = io.EOF
+		} else {
+			s.err = reason
+		}
+		close(s.ended)
+	})
+}
+
+// stream opens a stream to the method of slot, which is served by the method
+// taking IStream as first parameter or following context.Context.
+func (d *dock) stream(ctx context.Context, caller string, name string, method string, args ...interface{}) (IStream, error) {
+	target := d.local(name)
+	if nil != target && target.enter() {
+		if !target.permit(method, d.name, caller) {
+			target.exit()
+			return nil, denied(target, method)
+		}
+		return d.pipe(ctx, target, method, args), nil
+	}
+
+	r := newRpc()
+	s := newStream(ctx, r.index, false)
+	key := inbound{index: r.index}
+	d.streamsMutex.Lock()
+	d.streams[key] = s
+	d.streamsMutex.Unlock()
+
+	peer, err := r.open(ctx, d, caller, name, method, args...)
+	if nil != err {
+		d.drop(key)
+		s.cancel()
+		return nil, err
+	}
+
+	s.Lock()
+	s.peer = peer
+	s.Unlock()
+	s.remote = func(pack *packer) {
+		peer.Send(pack)
+	}
+
+	go func() {
+		select {
+		case <-s.ended:
+		case <-s.ctx.Done():
+			// Stop the method serving the stream if it's still running.
+			select {
+			case <-s.ended:
+			default:
+				peer.Send(&packer{
+					Id: cRpcCancel,
+					P:  &protoRpcCancel{Index: s.index},
+				})
+			}
+		}
+		d.drop(key)
+	}()
+
+	return s, nil
+}
+
+// pipe connects the stream to the slot in the same dock directly, and exits
+// the slot entered when the method returns.
+func (d *dock) pipe(ctx context.Context, target *slot, method string, args []interface{}) IStream {
+	index := atomic.AddInt64(&rpcIndex, 1)
+	a := newStream(ctx, index, false)
+	b := newStream(a.ctx, index, true)
+	a.remote = b.handle
+	b.remote = a.handle
+
+	go func() {
+		defer target.exit()
+
+		caller := chancall.NewCaller(target.callee)
+		b.end(failure(caller.CallStream(b.ctx, method, append([]interface{}{b}, args...)...)))
+	}()
+
+	return a
+}
+
+// serveStream runs the method of target serving the stream opened by the
+// dock at peer.
+func (d *dock) serveStream(peer network.IPeer, target *slot, req *protoRpcRequest) {
+	s := newStream(d.hold(peer, req), req.Index, true)
+	s.peer = peer
+	s.remote = func(pack *packer) {
+		peer.Send(pack)
+	}
+	key := inbound{peer: peer, index: req.Index}
+	d.streamsMutex.Lock()
+	d.streams[key] = s
+	d.streamsMutex.Unlock()
+
+	// Accept the stream before any frame.
+	peer.Send(&packer{
+		Id: cRpcResponse,
+		P: &protoRpcResponse{
+			Index:  req.Index,
+			Slot:   req.Slot,
+			Method: req.Method,
+		},
+	})
+
+	go func() {
+		defer d.serving.Done()
+		defer target.exit()
+		defer d.release(peer, req.Index)
+		defer d.drop(key)
+
+		caller := chancall.NewCaller(target.callee)
+		s.end(failure(caller.CallStream(s.ctx, req.Method, append([]interface{}{s}, req.Args...)...)))
+	}()
+}
+
+// relay delivers the frame from the dock at peer to the stream.
+func (d *dock) relay(peer network.IPeer, index int64, callee bool, pack *packer) {
+	// The frames from callee are for the stream opened by this dock.
+	key := inbound{index: index}
+	if !callee {
+		key.peer = peer
+	}
+
+	d.streamsMutex.Lock()
+	s, ok := d.streams[key]
+	d.streamsMutex.Unlock()
+
+	if ok {
+		s.handle(pack)
+	}
+}
+
+// drop forgets the stream.
+func (d *dock) drop(key inbound) {
+	d.streamsMutex.Lock()
+	defer d.streamsMutex.Unlock()
+
+	delete(d.streams, key)
+}
+
+// sever ends the streams opened to the dock at peer which is disconnected,
+// and the ones opened by it are cancelled with their calls.
+func (d *dock) sever(peer network.IPeer) {
+	d.streamsMutex.Lock()
+	severed := make([]*stream, 0)
+	for _, s := range d.streams {
+		s.Lock()
+		if !s.callee && s.peer == peer {
+			severed = append(severed, s)
+		}
+		s.Unlock()
+	}
+	d.streamsMutex.Unlock()
+
+	for _, s := range severed {
+		s.finish(newError(CodeUnavailable, "[%d] remote dock [%s] disconnected!", s.index, peer.RemoteAddr()))
+	}
+}
+
+// failure returns the error of the call, or the last return value if it's a
+// non-nil error.
+func failure(result []interface{}, err error) error {
+	_, err = settle(result, err)
+	return err
+}
+
+// settle moves the last return value of the call into the error if it's a
+// non-nil error, since the codec can't carry it as a result.
+func settle(result []interface{}, err error) ([]interface{}, error) {
+	if nil != err {
+		return result, err
+	}
+	if n := len(result); n > 0 {
+		if e, ok := result[n-1].(error); ok {
+			return result[:n-1], e
+		}
+	}
+
+	return result, nil
+}
@@ -26,6 +26,8 @@ const (
 	cGenFile string = "proxy.gen.go"
 )
 
+var notify = flag.Bool("notify", false, "Notify in one-way mode for methods returning nothing.")
+
 func main() {
 	flag.Parse()
 
@@ -165,6 +167,7 @@ func genProxyCode(targets []*astTarget) (data []byte, ok bool) {
 	input := map[string]interface{}{
 		"date":    time.Now().Format("2006-01-02 15:04:05"),
 		"targets": targets,
+		"notify":  *notify,
 	}
 
 	t, err := template.New("").Funcs(template.FuncMap{"comma": func(index int, length int) string {
@@ -272,11 +275,12 @@ type {{$target.Proxy}} struct {
 {{range $i, $method := $target.Methods}}
 func (p *{{$target.Proxy}}) {{$method.Name}}({{range $j, $param := $method.Params}}{{$param.Name}} {{$param.Type}}{{if le $j $method.PCount}}, {{end}}{{end}}){{if gt $method.RCount 0}}({{range $k, $result := $method.Results}}{{$result.Type}}{{comma $k $method.RCount}}{{end}}){{end}}{
 	{{if gt $method.RCount 0}}results, err := p.slot.CallWithResult("{{$target.Name}}", "{{$method.Name}}", {{range $j, $param := $method.Params}}{{$param.Name}}{{comma $j $method.PCount}}{{end}})
+	{{else if $.notify}}p.slot.Notify("{{$target.Name}}", "{{$method.Name}}", {{range $j, $param := $method.Params}}{{$param.Name}}{{comma $j $method.PCount}}{{end}})
 	{{else}}err := p.slot.Call("{{$target.Name}}", "{{$method.Name}}", {{range $j, $param := $method.Params}}{{$param.Name}}{{comma $j $method.PCount}}{{end}})
-	{{end}}
+	{{end}}{{if or (gt $method.RCount 0) (not $.notify)}}
 	if nil != err {
 		return {{range $k, $result := $method.Results}}{{default $result.Type}}{{comma $k $method.RCount}}{{end}}
-	}{{if gt $method.RCount 0}}
+	}{{end}}{{if gt $method.RCount 0}}
 	
 	return {{range $k, $result := $method.Results}}results[{{$k}}].({{$result.Type}}){{comma $k $method.RCount}}{{end}}{{end}}
 }
@@ -0,0 +1,361 @@
+// Copyright 2019 MuGuangyi. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ferry
+
+import (
+	"sync"
+
+	"github.com/muguangyi/ferry/network"
+)
+
+const (
+	cWatchBuffer int = 64
+)
+
+// SlotEventKind tells what happened to a slot instance.
+type SlotEventKind uint8
+
+const (
+	SlotAdded    SlotEventKind = 0x0 // Registered but not ready yet
+	SlotReady    SlotEventKind = 0x1 // Ready to serve
+	SlotRemoved  SlotEventKind = 0x2 // Gone with its dock
+	SlotDraining SlotEventKind = 0x3 // Not serving new calls as dock is closing
+
+	slotSynced SlotEventKind = 0xff // All instances are told after watched
+)
+
+// SlotEvent describes a change of a slot instance.
+type SlotEvent struct {
+	// Kind of the change.
+	Kind SlotEventKind
+
+	// ID of the slot.
+	ID string
+
+	// Addr of the dock hosting the slot instance.
+	Addr string
+}
+
+// subscribe adds peer as watcher of slots, and tells it all existing
+// instances of the slots.
+func (h *hub) subscribe(peer network.IPeer, slots []string) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	for _, slot := range slots {
+		watchers, ok := h.watchers[slot]
+		if !ok {
+			watchers = make(map[network.IPeer]bool)
+			h.watchers[slot] = watchers
+		}
+		watchers[peer] = true
+
+		if stubs, ok := h.docks[slot]; ok {
+			for i := stubs.Front(); i != nil; i = i.Next() {
+				stub := i.Value.(*stub)
+				tell(peer, SlotAdded, slot, stub.addr)
+				if stub.ready {
+					tell(peer, SlotReady, slot, stub.addr)
+				}
+			}
+		}
+		tell(peer, slotSynced, slot, "")
+	}
+}
+
+// unsubscribe drops all subscriptions of peer.
+func (h *hub) unsubscribe(peer network.IPeer) {
+	h.docksMutex.Lock()
+	defer h.docksMutex.Unlock()
+
+	for slot, watchers := range h.watchers {
+		delete(watchers, peer)
+		if 0 == len(watchers) {
+			delete(h.watchers, slot)
+		}
+	}
+}
+
+// notify tells all watchers of slot the change. It must be called with
+// docksMutex locked.
+func (h *hub) notify(kind SlotEventKind, slot string, addr string) {
+	for peer := range h.watchers[slot] {
+		tell(peer, kind, slot, addr)
+	}
+}
+
+func tell(peer network.IPeer, kind SlotEventKind, slot string, addr string) {
+	peer.Send(&packer{
+		Id: cSlotEvent,
+		P:  &protoSlotEvent{Op: uint8(kind), Slot: slot, Addr: addr},
+	})
+}
+
+// watch returns a channel receiving the changes of slot id for slot s. The
+// known instances are replayed if the slot is watched already, otherwise hub
+// tells them after subscribed.
+func (d *dock) watch(s *slot, id string) <-chan SlotEvent {
+	w := newWatcher(s)
+
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	if nil == d.watchers {
+		// Dock is closed.
+		w.stop()
+		return w.ch
+	}
+
+	d.watchers[id] = append(d.watchers[id], w)
+	if sight, ok := d.sights[id]; ok {
+		for addr, ready := range sight {
+			w.post(SlotEvent{Kind: SlotAdded, ID: id, Addr: addr})
+			if ready {
+				w.post(SlotEvent{Kind: SlotReady, ID: id, Addr: addr})
+			}
+		}
+		return w.ch
+	}
+
+	d.sights[id] = make(map[string]bool)
+	if hub := d.currentHub(); nil != hub {
+		hub.Send(&packer{
+			Id: cWatch,
+			P:  &protoWatch{Slots: []string{id}},
+		})
+	}
+
+	return w.ch
+}
+
+// unwatch stops the watcher receiving from ch, and ch is closed.
+func (d *dock) unwatch(ch <-chan SlotEvent) {
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	for id, watchers := range d.watchers {
+		for i, w := range watchers {
+			if w.ch != ch {
+				continue
+			}
+
+			w.stop()
+			d.watchers[id] = append(watchers[:i:i], watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// ignore stops the watchers created by slot s.
+func (d *dock) ignore(s *slot) {
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	for id, watchers := range d.watchers {
+		kept := watchers[:0:0]
+		for _, w := range watchers {
+			if w.slot == s {
+				w.stop()
+			} else {
+				kept = append(kept, w)
+			}
+		}
+		d.watchers[id] = kept
+	}
+}
+
+// rewatch subscribes all watched slots to hub again after registered. The
+// known instances are kept aside until hub tells all of its, and then the
+// ones gone or changed meanwhile are told to watchers.
+func (d *dock) rewatch(hub network.IPeer) {
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	if 0 == len(d.sights) {
+		return
+	}
+
+	slots := make([]string, 0, len(d.sights))
+	for id, sight := range d.sights {
+		d.stale[id] = sight
+		d.sights[id] = make(map[string]bool)
+		slots = append(slots, id)
+	}
+	hub.Send(&packer{
+		Id: cWatch,
+		P:  &protoWatch{Slots: slots},
+	})
+}
+
+// spread delivers the slot event from hub to all watchers.
+func (d *dock) spread(e *protoSlotEvent) {
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	sight, ok := d.sights[e.Slot]
+	if !ok {
+		return
+	}
+
+	kind := SlotEventKind(e.Op)
+	if slotSynced == kind {
+		d.reconcile(e.Slot)
+		return
+	}
+
+	switch kind {
+	case SlotAdded:
+		sight[e.Addr] = false
+	case SlotReady:
+		sight[e.Addr] = true
+	case SlotDraining:
+		sight[e.Addr] = false
+	case SlotRemoved:
+		delete(sight, e.Addr)
+	}
+
+	// The instances known before failover are told after reconciled.
+	if _, ok := d.stale[e.Slot][e.Addr]; ok {
+		return
+	}
+	d.announce(SlotEvent{Kind: kind, ID: e.Slot, Addr: e.Addr})
+}
+
+// reconcile tells watchers how the instances of slot id known before failover
+// changed, as hub has told all of its. It must be called with watchersMutex
+// locked.
+func (d *dock) reconcile(id string) {
+	stale, ok := d.stale[id]
+	if !ok {
+		return
+	}
+	delete(d.stale, id)
+
+	sight := d.sights[id]
+	for addr, was := range stale {
+		ready, ok := sight[addr]
+		if !ok {
+			d.announce(SlotEvent{Kind: SlotRemoved, ID: id, Addr: addr})
+		} else if ready && !was {
+			d.announce(SlotEvent{Kind: SlotReady, ID: id, Addr: addr})
+		} else if !ready && was {
+			d.announce(SlotEvent{Kind: SlotDraining, ID: id, Addr: addr})
+		}
+	}
+}
+
+// announce posts e to all watchers of the slot. It must be called with
+// watchersMutex locked.
+func (d *dock) announce(e SlotEvent) {
+	for _, w := range d.watchers[e.ID] {
+		w.post(e)
+	}
+}
+
+// blind stops all watchers.
+func (d *dock) blind() {
+	d.watchersMutex.Lock()
+	defer d.watchersMutex.Unlock()
+
+	for _, watchers := range d.watchers {
+		for _, w := range watchers {
+			w.stop()
+		}
+	}
+	d.watchers = nil
+	d.sights = nil
+	d.stale = nil
+}
+
+func newWatcher(s *slot) *watcher {
+	w := &watcher{
+		slot: s,
+		ch:   make(chan SlotEvent),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+// watcher delivers slot events to the channel returned by Watch. The events
+// not received yet are coalesced into the last one of each instance if there
+// are more than cWatchBuffer, so the latest change is never lost.
+type watcher struct {
+	sync.Mutex
+	slot    *slot // the slot watching.
+	ch      chan SlotEvent
+	pending []SlotEvent
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func (w *watcher) post(e SlotEvent) {
+	w.Lock()
+	w.pending = append(w.pending, e)
+	if len(w.pending) > cWatchBuffer {
+		w.pending = coalesce(w.pending)
+	}
+	w.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) run() {
+	defer close(w.ch)
+
+	for {
+		w.Lock()
+		n := len(w.pending)
+		var e SlotEvent
+		if n > 0 {
+			e = w.pending[0]
+			w.pending = w.pending[1:]
+		}
+		w.Unlock()
+
+		if 0 == n {
+			select {
+			case <-w.wake:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+
+		select {
+		case w.ch <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// stop closes the channel after the event being delivered, and it must be
+// called once.
+func (w *watcher) stop() {
+	close(w.done)
+}
+
+// coalesce keeps the last event of each instance in order.
+func coalesce(events []SlotEvent) []SlotEvent {
+	last := make(map[string]int, len(events))
+	for i, e := range events {
+		last[e.Addr] = i
+	}
+
+	result := make([]SlotEvent, 0, len(last))
+	for i, e := range events {
+		if last[e.Addr] == i {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}